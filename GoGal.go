@@ -1,67 +1,123 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/captainju/gogal/util"
 	"github.com/joho/godotenv"
-	"github.com/nfnt/resize"
-	"github.com/rwcarlsen/goexif/exif"
-	"image/jpeg"
+	"image"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/fcgi"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const checkpointInterval = 5 * time.Minute
+
 var (
 	wg                    sync.WaitGroup
-	jsonFilePhotoStore    util.JsonFilePhotoStore
+	photoStore            util.PhotoStore
 	s3Manager             util.S3Manager
 	cloudFrontManager     util.CloudFrontManager
 	imageSourceFolderPath string
 	httpPort              string
 	httpPrefix            string
 	cookieDomain          string
-	workers               chan struct{}
+	storeReloadInterval   time.Duration
+	watchRescanInterval   time.Duration
+	defaultLocation       *time.Location
+	eventMaxGap           time.Duration
+	eventMaxDistanceKm    float64
+	similarMaxDistance    int
+	deletePolicy          string
+	deleteMaxFraction     float64
+	renditions            []util.RenditionProfile
+	configuredRenditions  []util.RenditionProfile
+	dryRun                bool
+	report                = changeReport{counts: map[string]int{}}
+	addMutex              sync.Mutex
+	dryRunMoved           = map[string]bool{}
+	workers               = make(chan struct{}, 4)
 )
 
 func main() {
 	log.Println("Initializing...")
 	loadEnvVars()
 	initS3Manager()
-	initJsonFilePhotoStore()
+	initPhotoStore()
 	initCloudFrontManager()
 	log.Println("Init ok")
 
 	back := flag.Bool("back", false, "detect, resize and upload pictures")
 	eraseDB := flag.Bool("erasedb", false, "if running in back mode, replace data in DB")
+	forceDelete := flag.Bool("forcedelete", false, "in back mode or with serve -watch, apply the deletion policy even to more than DELETE_MAX_FRACTION of the photos")
+	flag.BoolVar(&dryRun, "dryrun", false, "if running in back mode, only report what would be added, uploaded, regenerated or deleted, with gc only list the orphaned objects")
 	fcgiServer := flag.Bool("fcgi", false, "run as a FastCGI server")
 	flag.Parse()
 
-	if *back {
-		runAsBack(*eraseDB)
-	} else {
-		runAsFront(*fcgiServer)
+	switch flag.Arg(0) {
+	case "serve":
+		serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+		watch := serveFlags.Bool("watch", false, "also watch the image source folder and ingest pictures as they change")
+		serveFcgi := serveFlags.Bool("fcgi", *fcgiServer, "run as a FastCGI server")
+		serveFlags.Parse(flag.Args()[1:])
+		if *watch {
+			go runWatcher(*forceDelete)
+		}
+		runAsFront(*serveFcgi, !*watch)
+	case "":
+		if *back {
+			runAsBack(*eraseDB, *forceDelete)
+		} else {
+			runAsFront(*fcgiServer, true)
+		}
+	case "reindex":
+		runReindex()
+	case "duplicates":
+		runDuplicates()
+	case "gc":
+		runGc()
+	case "similar":
+		similarFlags := flag.NewFlagSet("similar", flag.ExitOnError)
+		distance := similarFlags.Int("distance", similarMaxDistance, "maximum number of bits the perceptual hashes of similar photos differ by")
+		similarFlags.Parse(flag.Args()[1:])
+		runSimilar(*distance)
+	case "regenerate":
+		regenerateFlags := flag.NewFlagSet("regenerate", flag.ExitOnError)
+		all := regenerateFlags.Bool("all", false, "regenerate every photo, not only the rotated or flipped ones")
+		regenerateFlags.Parse(flag.Args()[1:])
+		runRegenerate(*all)
+	default:
+		log.Fatalln("Unknown command", flag.Arg(0))
 	}
 }
 
-func runAsFront(fcgiServer bool) {
+// runAsFront serves the gallery. reload should be true when another process
+// feeds the photo store.
+func runAsFront(fcgiServer bool, reload bool) {
+	if reload && storeReloadInterval > 0 {
+		go reloadPhotoStore()
+	}
 	if fcgiServer {
 		listener, _ := net.Listen("tcp", ":"+httpPort)
 		http.Handle(httpPrefix+"/static/", http.StripPrefix(httpPrefix+"/static/", http.FileServer(http.Dir("static/"))))
 		serveSingle(httpPrefix+"/", "static/main.html")
 		http.HandleFunc(httpPrefix+"/albums.json", albumsHandler)
 		http.HandleFunc(httpPrefix+"/images.json", imagesHandler)
+		http.HandleFunc(httpPrefix+"/similar.json", similarHandler)
 		log.Println("Running as a FastCGI server on", listener.Addr().String())
 		log.Println(fcgi.Serve(listener, nil))
 	} else {
@@ -69,28 +125,60 @@ func runAsFront(fcgiServer bool) {
 		serveSingle("/", "static/main.html")
 		http.HandleFunc("/albums.json", albumsHandler)
 		http.HandleFunc("/images.json", imagesHandler)
+		http.HandleFunc("/similar.json", similarHandler)
 		log.Println("Listening... ", ":"+httpPort)
 		log.Println(http.ListenAndServe(":"+httpPort, nil))
 	}
 }
 
+// reloadPhotoStore polls the photo store for changes made by a back run.
+func reloadPhotoStore() {
+	for range time.Tick(storeReloadInterval) {
+		if err := photoStore.Reload(); err != nil {
+			log.Println("Can't reload photos :", err)
+		}
+	}
+}
+
 func albumsHandler(w http.ResponseWriter, r *http.Request) {
-	albums := []string{}
-PhtotSreamLoop:
-	for _, photo := range jsonFilePhotoStore.GetAll() {
-		timestamp := strconv.Itoa(photo.AlbumDateTime)
-		for _, b := range albums {
-			if b == timestamp {
-				continue PhtotSreamLoop
-			}
+	r.ParseForm()
+	albumType := r.Form.Get("type")
+	if albumType == "" {
+		albumType = "date"
+	}
+	albums, err := buildAlbums(albumType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range albums {
+		if albums[i].CoverKey != "" {
+			albums[i].CoverUrl = objectUrl(coverRendition().Key(albums[i].CoverKey))
 		}
-		albums = append(albums, timestamp)
 	}
-	sort.Sort(sort.Reverse(sort.StringSlice(albums)))
-	slcB, _ := json.Marshal(albums)
+	slcB, err := json.Marshal(albums)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/javascript")
 	cloudFrontManager.WriteCookies(w, cookieDomain)
-	fmt.Fprintf(w, string(slcB))
+	w.Write(slcB)
+}
+
+// buildAlbums builds the albums of the given type (date, folder, event)
+// from all the photos but the hidden ones. Duplicates are only shown in the
+// folder they are in.
+func buildAlbums(albumType string) ([]util.Album, error) {
+	switch albumType {
+	case "date":
+		return util.DateAlbums(util.WithoutDuplicates(util.WithoutHidden(photoStore.GetAll()))), nil
+	case "folder":
+		return util.FolderAlbums(util.WithoutHidden(photoStore.GetAll())), nil
+	case "event":
+		return util.EventAlbums(util.WithoutDuplicates(util.WithoutHidden(photoStore.GetAll())), eventMaxGap, eventMaxDistanceKm), nil
+	}
+	return nil, errors.New("unknown album type " + albumType)
 }
 
 func imagesHandler(w http.ResponseWriter, r *http.Request) {
@@ -99,21 +187,141 @@ func imagesHandler(w http.ResponseWriter, r *http.Request) {
 
 	photos := []util.Photo{}
 
-	for _, photo := range jsonFilePhotoStore.GetAll() {
-		photoAlbumTimestamp := strconv.Itoa(photo.AlbumDateTime)
-		for _, albumTimestamp := range r.Form["albums"] {
-			if albumTimestamp == photoAlbumTimestamp {
-				photo.ThumbUrl = cloudFrontManager.BaseUrl + "/" + s3Manager.ThumbPath + photo.Filename
-				photo.MediumUrl = cloudFrontManager.BaseUrl + "/" + s3Manager.MediumPath + photo.Filename
-				photos = append(photos, photo)
-			}
+	for _, albumTimestamp := range r.Form["albums"] {
+		albumDateTime, err := strconv.Atoi(albumTimestamp)
+		if err != nil {
+			continue
+		}
+		photos = append(photos, util.WithoutDuplicates(util.WithoutHidden(photoStore.GetByAlbum(albumDateTime)))...)
+	}
+
+	// folder and event albums IDs are prefixed with their type
+	albumsByType := map[string][]util.Album{}
+	for _, albumID := range r.Form["album"] {
+		albumType := strings.SplitN(albumID, "-", 2)[0]
+		if _, built := albumsByType[albumType]; !built {
+			albumsByType[albumType], _ = buildAlbums(albumType)
 		}
+		album, found := util.FindAlbum(albumsByType[albumType], albumID)
+		if !found {
+			continue
+		}
+		photos = append(photos, album.Photos...)
 	}
 
 	sort.Sort(util.ByDateTime(photos))
-	slcB, _ := json.Marshal(photos)
+	response := []photoWithUrls{}
+	for _, group := range util.GroupPhotos(photos) {
+		item := withUrls(group.Primary)
+		for _, member := range group.Members {
+			item.Members = append(item.Members, withUrls(member))
+		}
+		response = append(response, item)
+	}
+	slcB, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/javascript")
-	fmt.Fprintf(w, string(slcB))
+	w.Write(slcB)
+}
+
+// similarHandler serves the photos looking like the one named by the photo
+// parameter, closest first, at most distance (or SIMILAR_MAX_DISTANCE) bits
+// apart.
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	photo, err := photoStore.Get(r.Form.Get("photo"))
+	if err != nil || photo.Hidden {
+		http.Error(w, "No photo found for filename "+r.Form.Get("photo"), http.StatusNotFound)
+		return
+	}
+	maxDistance := similarMaxDistance
+	if distance := r.Form.Get("distance"); distance != "" {
+		maxDistance, err = strconv.Atoi(distance)
+		if err != nil || maxDistance < 0 {
+			http.Error(w, "invalid distance "+distance, http.StatusBadRequest)
+			return
+		}
+	}
+	response := []similarPhoto{}
+	for _, similar := range util.SimilarPhotos(photoStore.GetAll(), photo, maxDistance) {
+		response = append(response, similarPhoto{photoWithUrls: withUrls(similar.Photo), Distance: similar.Distance})
+	}
+	slcB, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Write(slcB)
+}
+
+// similarPhoto is a photo as served by similar.json, with the number of bits
+// its perceptual hash differs by.
+type similarPhoto struct {
+	photoWithUrls
+	Distance int
+}
+
+// photoWithUrls is a photo as served by images.json, with the URL of each
+// of its renditions by profile name (and of its original and RAW files),
+// and for the profiles having alternative formats the <source> elements of
+// a <picture>, preferred first. The primary photo of a group holds the
+// other photos of the group.
+type photoWithUrls struct {
+	util.Photo
+	Urls    map[string]string
+	Sources map[string][]pictureSource `json:",omitempty"`
+	Members []photoWithUrls            `json:",omitempty"`
+}
+
+type pictureSource struct {
+	Type   string
+	Srcset string
+}
+
+// withUrls fills the URLs of the photo renditions, if it has some (videos
+// have none until their poster is extracted). ThumbUrl and MediumUrl are
+// kept for the thumb and medium profiles.
+func withUrls(photo util.Photo) photoWithUrls {
+	urls := map[string]string{}
+	sources := map[string][]pictureSource{}
+	for _, profile := range renditions {
+		if !photo.HasRenditions() {
+			continue
+		}
+		urls[profile.Name] = objectUrl(profile.Key(photo.Key()))
+		if profile.AlternativeOf != "" {
+			source := pictureSource{Type: profile.ContentType(), Srcset: urls[profile.Name]}
+			sources[profile.AlternativeOf] = append(sources[profile.AlternativeOf], source)
+		}
+	}
+	urls["original"] = objectUrl(s3Manager.ImagePath + photo.Key())
+	if photo.RawFilename != "" {
+		urls["raw"] = objectUrl(s3Manager.ImagePath + photo.RawFilename)
+	}
+	photo.ThumbUrl = urls["thumb"]
+	photo.MediumUrl = urls["medium"]
+	return photoWithUrls{Photo: photo, Urls: urls, Sources: sources}
+}
+
+// coverRendition returns the profile used for album covers: thumb, or the
+// first one.
+func coverRendition() util.RenditionProfile {
+	for _, profile := range renditions {
+		if profile.Name == "thumb" {
+			return profile
+		}
+	}
+	return renditions[0]
+}
+
+// objectUrl returns the CloudFront URL of the S3 object key.
+func objectUrl(key string) string {
+	u := url.URL{Path: "/" + key}
+	return cloudFrontManager.BaseUrl + u.EscapedPath()
 }
 
 func serveSingle(pattern string, filename string) {
@@ -122,24 +330,554 @@ func serveSingle(pattern string, filename string) {
 	})
 }
 
-func runAsBack(eraseDb bool) {
+func runAsBack(eraseDb bool, forceDelete bool) {
+	if dryRun {
+		runDryRun(forceDelete)
+		return
+	}
 	if eraseDb {
-		jsonFilePhotoStore.RemoveStorageFile()
-		jsonFilePhotoStore.Touch()
+		err := photoStore.Erase()
+		if err != nil {
+			panic("Error erasing photos : " + err.Error())
+		}
+	}
+
+	stopCheckpoints := make(chan struct{})
+	go checkpointPhotoStore(stopCheckpoints)
+
+	names, err := sourceNames()
+	if err != nil {
+		log.Println("Can't walk image source folder :", err)
+	}
+
+	migrateToRelativePaths(names)
+
+	for _, name := range names {
+		ingest(name, false)
+	}
+	wg.Wait()
+	if err == nil {
+		syncDeletions(names, forceDelete)
+	}
+	close(stopCheckpoints)
+	err = photoStore.Flush()
+	if err != nil {
+		log.Println("Can't store photos :", err)
+	}
+	report.print()
+}
+
+// runDryRun reports what a back run would add, upload, regenerate and
+// delete, without changing the photo store nor S3.
+func runDryRun(forceDelete bool) {
+	names, err := sourceNames()
+	if err != nil {
+		log.Println("Can't walk image source folder :", err)
 	}
+	for _, name := range names {
+		ingest(name, false)
+	}
+	wg.Wait()
+	if err == nil {
+		syncDeletions(names, forceDelete)
+	}
+	report.print()
+}
 
-	workers = make(chan struct{}, 4)
+// syncDeletions applies the deletion policy to the photos whose source file
+// is not among names anymore, hidden ones included unless the policy is to
+// hide them, and to the RAW files which are gone. Unless forced, it does
+// nothing when more than deleteMaxFraction of the photos went missing, as
+// when the source folder is not mounted.
+func syncDeletions(names []string, force bool) {
+	if deletePolicy == util.DeleteKeep {
+		return
+	}
+	photos := photoStore.GetAll()
+	missing := []util.Photo{}
+	for _, photo := range util.MissingSources(photos, names) {
+		// a dry run only reports moves, the photo is still at its former place
+		if !dryRunMoved[photo.Filename] {
+			missing = append(missing, photo)
+		}
+	}
+	if !deletionAllowed(len(util.WithoutHidden(missing)), len(photos), force) {
+		return
+	}
+	for _, photo := range missing {
+		if photo.Hidden && deletePolicy == util.DeleteHide {
+			continue
+		}
+		forget(photo.Filename)
+	}
+	for _, rawFilename := range util.MissingRaws(photos, names) {
+		unlinkRaw(rawFilename)
+	}
+}
 
-	filepath.Walk(imageSourceFolderPath, func(path string, info os.FileInfo, err error) error {
+// deletionAllowed tells whether the deletion policy can be applied to
+// missing photos out of total: unless forced, to at most deleteMaxFraction
+// of them, as more are missing when the source folder is not mounted.
+func deletionAllowed(missing int, total int, force bool) bool {
+	if force || float64(missing) <= deleteMaxFraction*float64(total) {
+		return true
+	}
+	log.Printf("%d of %d photos have no source file anymore, more than DELETE_MAX_FRACTION : is the source folder mounted ? Run with -forcedelete to %s them anyway", missing, total, deletePolicy)
+	return false
+}
+
+// sourceNames returns the slash separated names of all the files of the
+// image source folder, relative to it.
+func sourceNames() ([]string, error) {
+	names := []string{}
+	err := filepath.Walk(imageSourceFolderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if !info.IsDir() {
-			wg.Add(1)
-			workers <- struct{}{}
-			go handleFile(info.Name())
+			rel, err := filepath.Rel(imageSourceFolderPath, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
 		}
-		return err
+		return nil
 	})
-	wg.Wait()
-	jsonFilePhotoStore.StoreToFile()
+	return names, err
+}
+
+// runDuplicates lists the source files found more than once, each set of
+// copies with the original first.
+func runDuplicates() {
+	sets := util.DuplicateSets(photoStore.GetAll())
+	if len(sets) == 0 {
+		log.Println("No duplicates found")
+		return
+	}
+	wasted := int64(0)
+	for _, set := range sets {
+		log.Printf("%s (%d bytes)", set[0].Hash, set[0].FileSize)
+		for _, photo := range set {
+			if photo.DuplicateOf == "" {
+				log.Printf("  %s (original)", photo.Filename)
+			} else {
+				log.Printf("  %s", photo.Filename)
+				wasted += photo.FileSize
+			}
+		}
+	}
+	log.Printf("%d files with duplicates, %d bytes in duplicates", len(sets), wasted)
+}
+
+// runGc lists the objects under the image and rendition paths which no
+// photo references, with their sizes, and deletes them once confirmed.
+// Every configured rendition is referenced, including the ones this host
+// has no encoder for.
+func runGc() {
+	if s3Manager.ImagePath == "" {
+		log.Println("S3_IMAGE_FOLDER_PATH is empty, gc would list the whole bucket : nothing to do")
+		return
+	}
+	prefixes := []string{s3Manager.ImagePath}
+	for _, profile := range configuredRenditions {
+		if profile.Path == "" {
+			log.Printf("The %s renditions have no path, gc would list the whole bucket : nothing to do", profile.Name)
+			return
+		}
+		prefixes = append(prefixes, profile.Path)
+	}
+	objects := map[string]int64{}
+	for _, prefix := range util.DistinctPrefixes(prefixes) {
+		listed, err := s3Manager.ListObjects(prefix)
+		if err != nil {
+			log.Println("Can't list objects :", err)
+			return
+		}
+		for key, size := range listed {
+			objects[key] = size
+		}
+	}
+	// read once the objects are listed: photos are stored before their
+	// objects are uploaded
+	if err := photoStore.Reload(); err != nil {
+		log.Println("Can't reload photos :", err)
+		return
+	}
+	photos := photoStore.GetAll()
+	if len(photos) == 0 {
+		log.Println("No photos stored, every object would be orphaned : nothing to do")
+		return
+	}
+
+	orphans := util.Orphans(objects, util.ReferencedKeys(photos, s3Manager.ImagePath, configuredRenditions), s3Manager.TrashPath)
+	total := int64(0)
+	for _, key := range orphans {
+		log.Printf("%s (%d bytes)", key, objects[key])
+		total += objects[key]
+	}
+	log.Printf("%d orphaned objects of %d, %d bytes", len(orphans), len(objects), total)
+	if len(orphans) == 0 || dryRun {
+		return
+	}
+
+	fmt.Printf("Delete these %d objects ? [y/N] ", len(orphans))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		log.Println("Nothing deleted")
+		return
+	}
+	if err := s3Manager.DeleteObjects(orphans); err != nil {
+		log.Println("Can't delete orphaned objects :", err)
+		return
+	}
+	log.Printf("%d objects deleted", len(orphans))
+}
+
+// runSimilar lists the clusters of photos looking alike, their perceptual
+// hashes at most maxDistance bits apart.
+func runSimilar(maxDistance int) {
+	photos := photoStore.GetAll()
+	missing := 0
+	for _, photo := range photos {
+		if photo.PerceptualHash == "" && photo.DuplicateOf == "" {
+			missing++
+		}
+	}
+	if missing > 0 {
+		log.Printf("%d photos have no perceptual hash yet, regenerate computes them", missing)
+	}
+	clusters := util.SimilarClusters(photos, maxDistance)
+	for _, cluster := range clusters {
+		log.Printf("%d similar photos", len(cluster))
+		for _, photo := range cluster {
+			log.Printf("  %s (%s)", photo.Filename, photo.PerceptualHash)
+		}
+	}
+	log.Printf("%d clusters of similar photos", len(clusters))
+}
+
+// runReindex reads the metadata of every stored photo again from its source
+// file, to backfill photos stored by older versions.
+func runReindex() {
+	for _, stored := range photoStore.GetAll() {
+		photo, err := readPhoto(stored.Filename)
+		if err != nil {
+			log.Printf("Can't reindex %s : %s\n", stored.Filename, err.Error())
+			continue
+		}
+		photo.CarryOver(stored)
+		if photo == stored {
+			continue
+		}
+		if err := photoStore.Update(photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", stored.Filename, err.Error())
+			continue
+		}
+		log.Printf("%s reindexed", stored.Filename)
+	}
+	if err := photoStore.Flush(); err != nil {
+		log.Println("Can't store photos :", err)
+	}
+}
+
+// runRegenerate resizes and uploads again the thumb and medium of the
+// photos needing a rotation or a flip (all of them if all is true), which
+// were uploaded sideways by older versions. The metadata of the photos is
+// reindexed on the way, and the photos rendered before perceptual hashes
+// get theirs.
+func runRegenerate(all bool) {
+	for _, stored := range photoStore.GetAll() {
+		regenerate(stored, all)
+	}
+	if err := photoStore.Flush(); err != nil {
+		log.Println("Can't store photos :", err)
+	}
+}
+
+func regenerate(stored util.Photo, all bool) {
+	f, err := os.Open(sourcePath(stored.Filename))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	photo, decoded, err := createPhoto(stored.Filename, f)
+	if err != nil {
+		log.Printf("Can't read %s : %s\n", stored.Filename, err.Error())
+		return
+	}
+	photo.CarryOver(stored)
+	// duplicates share the renditions of their original
+	if photo.DuplicateOf == "" && (all || photo.Orientation > 1) {
+		if perceptualHash, err := uploadRenditions(photo, f, decoded, renditions); err != nil {
+			log.Printf("Can't regenerate %s : %s\n", stored.Filename, err.Error())
+		} else {
+			photo.RenditionsHash = util.RenditionsHash(renditions)
+			photo.PerceptualHash = perceptualHash
+		}
+	} else if photo.DuplicateOf == "" && photo.PerceptualHash == "" {
+		// rendered before perceptual hashes were computed
+		if img, err := decodeSource(photo, f, decoded); err != nil {
+			log.Printf("Can't compute the perceptual hash of %s : %s\n", stored.Filename, err.Error())
+		} else {
+			photo.PerceptualHash = util.PerceptualHash(img)
+		}
+	}
+	if photo != stored {
+		if err := photoStore.Update(photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", stored.Filename, err.Error())
+		}
+	}
+}
+
+// migrateToRelativePaths renames the photos stored by bare file name by
+// older versions, names being the source files, and copies their objects.
+func migrateToRelativePaths(names []string) {
+	renamed, err := util.MigrateToRelativePaths(photoStore, names)
+	if err != nil {
+		log.Println("Can't migrate photos to relative paths :", err)
+	}
+	for oldName, newName := range renamed {
+		log.Printf("%s migrated to %s", oldName, newName)
+		if err := s3Manager.CopyFiles(oldName, newName); err != nil {
+			log.Printf("Can't copy %s to %s : %s\n", oldName, newName, err.Error())
+		}
+	}
+}
+
+// runWatcher ingests the pictures of the image source folder, applies the
+// deletion policy to the ones removed while it was not running, then keeps
+// the photo store in sync with the folder.
+func runWatcher(forceDelete bool) {
+	if names, err := sourceNames(); err != nil {
+		log.Println("Can't walk image source folder :", err)
+	} else {
+		migrateToRelativePaths(names)
+	}
+	go checkpointPhotoStore(make(chan struct{}))
+	folderWatcher := util.FolderWatcher{
+		Root:           imageSourceFolderPath,
+		RescanInterval: watchRescanInterval,
+		Settle:         5 * time.Second,
+		Created: func(name string) {
+			ingest(name, false)
+		},
+		Changed: func(name string) {
+			log.Printf("%s changed", name)
+			ingest(name, true)
+		},
+		Removed: func(names []string) {
+			forgetRemoved(names, forceDelete)
+		},
+		Moved: func(oldName string, newName string) {
+			ingestMove(oldName, newName)
+		},
+		Ready: func() {
+			// moved files must be ingested first, not to be taken as removed
+			wg.Wait()
+			names, err := sourceNames()
+			if err != nil {
+				log.Println("Can't walk image source folder :", err)
+				return
+			}
+			syncDeletions(names, forceDelete)
+		},
+	}
+	folderWatcher.Run(make(chan struct{}))
+}
+
+// forgetRemoved applies the deletion policy to the source files the watcher
+// found removed in one scan, within the same limit as syncDeletions.
+func forgetRemoved(names []string, force bool) {
+	if deletePolicy == util.DeleteKeep {
+		return
+	}
+	missing := 0
+	for _, name := range names {
+		if photo, err := photoStore.Get(name); err == nil && !photo.Hidden {
+			missing++
+		}
+	}
+	if !deletionAllowed(missing, len(photoStore.GetAll()), force) {
+		return
+	}
+	for _, name := range names {
+		forget(name)
+	}
+}
+
+// forget applies the deletion policy to the photo of the source file name,
+// which was removed.
+func forget(name string) {
+	photo, err := photoStore.Get(name)
+	if err != nil {
+		unlinkRaw(name)
+		return
+	}
+	if deletePolicy == util.DeleteKeep || (photo.Hidden && deletePolicy == util.DeleteHide) {
+		return
+	}
+	report.record(deletePolicy+" removed photo", name)
+	if dryRun {
+		return
+	}
+	log.Printf("%s removed, %s", name, deletePolicy)
+	if deletePolicy == util.DeleteHide {
+		hidePhoto(photo)
+		return
+	}
+	removePhoto(photo)
+	keys := []string{}
+	if !util.KeyShared(photoStore.GetAll(), photo) {
+		keys = append(keys, photo.Key())
+	}
+	if photo.RawFilename != "" && !sourceExists(photo.RawFilename) {
+		keys = append(keys, photo.RawFilename)
+	}
+	deleteObjects(keys)
+}
+
+// hidePhoto hides a photo whose source file was removed, its first
+// duplicate taking its place.
+func hidePhoto(photo util.Photo) {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+	photo.Hidden = true
+	if err := photoStore.Update(photo); err != nil {
+		log.Printf("Can't store photo %s : %s\n", photo.Filename, err.Error())
+		return
+	}
+	if err := util.PromoteDuplicate(photoStore, photo); err != nil {
+		log.Printf("Can't promote a duplicate of %s : %s\n", photo.Filename, err.Error())
+	}
+}
+
+// restorePhoto shows again a hidden photo whose source file came back, as
+// the duplicate of the photo which took its place if there is one.
+func restorePhoto(photo *util.Photo) error {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+	report.record("restored photo", photo.Filename)
+	photo.Hidden = false
+	if original, found := util.FindOriginal(photoStore.GetByHash(photo.Hash), photo.Hash, photo.Filename); found {
+		photo.DuplicateOf = original.Filename
+	}
+	if dryRun {
+		return nil
+	}
+	return photoStore.Update(*photo)
+}
+
+// deleteObjects moves to the trash, or deletes with the purge policy, the
+// objects stored under keys (see Photo.Key).
+func deleteObjects(keys []string) {
+	objects := []string{}
+	for _, key := range keys {
+		fileKeys, err := s3Manager.FileKeys(key)
+		if err != nil {
+			log.Printf("Can't list objects of %s : %s\n", key, err.Error())
+			continue
+		}
+		objects = append(objects, fileKeys...)
+	}
+	if len(objects) == 0 {
+		return
+	}
+	var err error
+	if deletePolicy == util.DeleteTrash {
+		err = s3Manager.TrashObjects(objects)
+	} else {
+		err = s3Manager.DeleteObjects(objects)
+	}
+	if err != nil {
+		log.Printf("Can't %s objects of %v : %s\n", deletePolicy, keys, err.Error())
+	}
+}
+
+// unlinkRaw forgets the RAW file rawFilename on the photo of its JPEG.
+func unlinkRaw(rawFilename string) {
+	for _, jpegFilename := range util.JpegSiblings(rawFilename) {
+		photo, err := photoStore.Get(jpegFilename)
+		if err != nil || photo.RawFilename != rawFilename {
+			continue
+		}
+		report.record(deletePolicy+" removed raw", rawFilename)
+		if dryRun {
+			continue
+		}
+		photo.RawFilename = ""
+		if err := photoStore.Update(photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", jpegFilename, err.Error())
+		}
+		if deletePolicy == util.DeleteTrash || deletePolicy == util.DeletePurge {
+			deleteObjects([]string{rawFilename})
+		}
+	}
+}
+
+// ingest runs handleFile in a worker, overwriting the stored photo and its
+// uploads if overwrite is true.
+func ingest(sourceFilename string, overwrite bool) {
+	inWorker(func() {
+		handleFile(sourceFilename, overwrite)
+	})
+}
+
+// ingestMove ingests a source file moved from oldName, then forgets oldName
+// unless handleFile recognised the move and moved its photo. oldName is kept
+// when newName could not be stored, so that a failed ingest never deletes
+// the objects of a photo which still exists.
+func ingestMove(oldName string, newName string) {
+	inWorker(func() {
+		handleFile(newName, false)
+		if !isStored(newName) {
+			log.Printf("%s not stored, %s kept\n", newName, oldName)
+			return
+		}
+		forget(oldName)
+	})
+}
+
+// isStored tells whether the source file name is stored, as a photo or as
+// the RAW file of the photo of its JPEG.
+func isStored(name string) bool {
+	if _, err := photoStore.Get(name); err == nil {
+		return true
+	}
+	jpegFilename, paired := pairedJpeg(name)
+	if !paired {
+		return false
+	}
+	photo, err := photoStore.Get(jpegFilename)
+	return err == nil && photo.RawFilename == name
+}
+
+// inWorker runs fn in one of the workers.
+func inWorker(fn func()) {
+	wg.Add(1)
+	workers <- struct{}{}
+	go func() {
+		defer wg.Done()
+		defer func() { <-workers }()
+		fn()
+	}()
+}
+
+// checkpointPhotoStore flushes the photo store every checkpointInterval
+// until stop is closed, so that long ingests are persisted along the way.
+func checkpointPhotoStore(stop chan struct{}) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := photoStore.Flush(); err != nil {
+				log.Println("Can't checkpoint photos :", err)
+			}
+		case <-stop:
+			return
+		}
+	}
 }
 
 func loadEnvVars() {
@@ -161,16 +899,105 @@ func loadEnvVars() {
 	if cookieDomain == "" {
 		panic("cookie domain not configured")
 	}
+	storeReloadInterval = 30 * time.Second
+	if interval := os.Getenv("STORE_RELOAD_INTERVAL"); interval != "" {
+		storeReloadInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			panic("invalid store reload interval : " + err.Error())
+		}
+	}
+	watchRescanInterval = 10 * time.Minute
+	if interval := os.Getenv("WATCH_RESCAN_INTERVAL"); interval != "" {
+		watchRescanInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			panic("invalid watch rescan interval : " + err.Error())
+		}
+		if watchRescanInterval < 0 {
+			panic("invalid watch rescan interval : " + interval + " is negative")
+		}
+	}
+	defaultLocation = time.Local
+	if timeZone := os.Getenv("DEFAULT_TIMEZONE"); timeZone != "" {
+		defaultLocation, err = time.LoadLocation(timeZone)
+		if err != nil {
+			panic("invalid default time zone : " + err.Error())
+		}
+	}
+	eventMaxGap = 3 * time.Hour
+	if gap := os.Getenv("ALBUM_EVENT_GAP"); gap != "" {
+		eventMaxGap, err = time.ParseDuration(gap)
+		if err != nil {
+			panic("invalid album event gap : " + err.Error())
+		}
+	}
+	if distance := os.Getenv("ALBUM_EVENT_DISTANCE_KM"); distance != "" {
+		eventMaxDistanceKm, err = strconv.ParseFloat(distance, 64)
+		if err != nil {
+			panic("invalid album event distance : " + err.Error())
+		}
+	}
+	similarMaxDistance = 10
+	if distance := os.Getenv("SIMILAR_MAX_DISTANCE"); distance != "" {
+		similarMaxDistance, err = strconv.Atoi(distance)
+		if err != nil {
+			panic("invalid similar max distance : " + err.Error())
+		}
+	}
+	deletePolicy, err = util.ParseDeletePolicy(os.Getenv("DELETE_POLICY"))
+	if err != nil {
+		panic("invalid delete policy : " + err.Error())
+	}
+	if deletePolicy == util.DeleteTrash && os.Getenv("S3_TRASH_FOLDER_PATH") == "" {
+		panic("the trash delete policy needs S3_TRASH_FOLDER_PATH")
+	}
+	deleteMaxFraction = 0.1
+	if fraction := os.Getenv("DELETE_MAX_FRACTION"); fraction != "" {
+		deleteMaxFraction, err = strconv.ParseFloat(fraction, 64)
+		if err != nil {
+			panic("invalid delete max fraction : " + err.Error())
+		}
+	}
+	renditions, err = util.ParseRenditionProfiles(os.Getenv("RENDITIONS"))
+	if err != nil {
+		panic("invalid renditions : " + err.Error())
+	}
+	if len(renditions) == 0 {
+		renditions = []util.RenditionProfile{
+			{Name: "thumb", Height: 162, Fit: util.FitContain, Format: "jpeg", Quality: 75, Path: os.Getenv("S3_THUMB_FOLDER_PATH")},
+			{Name: "medium", Height: 768, Fit: util.FitContain, Format: "jpeg", Quality: 75, Path: os.Getenv("S3_MEDIUM_FOLDER_PATH")},
+		}
+	}
+	// gc must know the objects of every profile, even the ones this host
+	// can't encode
+	configuredRenditions = renditions
+	available := []util.RenditionProfile{}
+	for _, profile := range renditions {
+		if util.EncoderAvailable(profile.Format) {
+			available = append(available, profile)
+		} else {
+			log.Printf("No %s encoder found, %s renditions disabled", profile.Format, profile.Name)
+		}
+	}
+	renditions = available
+	if len(renditions) == 0 {
+		panic("no rendition can be encoded")
+	}
+	if !util.PosterAvailable() {
+		log.Println("ffmpeg not found, videos will have no poster")
+	}
 	log.Println("image folder ok")
 }
 
+// initS3Manager connects to S3. The manager gets every configured
+// rendition, so that copying and deleting the objects of a photo covers the
+// profiles this host has no encoder for.
 func initS3Manager() {
 	s3Manager = util.S3Manager{
 		Bucket:              os.Getenv("S3_BUCKET"),
 		Region:              os.Getenv("S3_REGION"),
 		ImagePath:           os.Getenv("S3_IMAGE_FOLDER_PATH"),
-		ThumbPath:           os.Getenv("S3_THUMB_FOLDER_PATH"),
-		MediumPath:          os.Getenv("S3_MEDIUM_FOLDER_PATH"),
+		Renditions:          configuredRenditions,
+		TrashPath:           os.Getenv("S3_TRASH_FOLDER_PATH"),
 		NbConcurrentUploads: 2,
 	}
 	err := s3Manager.Connect()
@@ -180,19 +1007,32 @@ func initS3Manager() {
 	log.Println("S3 ok")
 }
 
-func initJsonFilePhotoStore() {
-	jsonFilePhotoStore = util.JsonFilePhotoStore{
-		FileName: os.Getenv("JSON_FILE_NAME"),
-	}
-	err := jsonFilePhotoStore.Touch()
-	if err != nil {
-		panic("Error file : " + err.Error())
+func initPhotoStore() {
+	storeType := os.Getenv("PHOTO_STORE")
+	switch storeType {
+	case "", "json":
+		storeType = "json"
+		photoStore = &util.JsonFilePhotoStore{
+			FileName: os.Getenv("JSON_FILE_NAME"),
+		}
+	case "bolt":
+		photoStore = &util.BoltPhotoStore{
+			FileName: os.Getenv("BOLT_FILE_NAME"),
+		}
+	case "mongo":
+		photoStore = &util.MongoPhotoStore{
+			Url:            os.Getenv("MONGODB_URL"),
+			DbName:         os.Getenv("MONGODB_DB_NAME"),
+			CollectionName: os.Getenv("MONGODB_COLLECTION_NAME"),
+		}
+	default:
+		panic("unknown photo store : " + storeType)
 	}
-	err = jsonFilePhotoStore.LoadFromFile()
+	err := photoStore.Init()
 	if err != nil {
-		panic("Error loading photos : " + err.Error())
+		panic("Error photo store : " + err.Error())
 	}
-	log.Println("JsonFilePhotoStore ok")
+	log.Println("PhotoStore ok (" + storeType + ")")
 }
 
 func initCloudFrontManager() {
@@ -212,115 +1052,469 @@ func initCloudFrontManager() {
 	log.Println("CloudFront ok")
 }
 
-func handleFile(sourceFilename string) {
-	defer wg.Done()
-	defer func() { <-workers }()
+func handleFile(sourceFilename string, overwrite bool) {
+	f, err := os.Open(sourcePath(sourceFilename))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	if jpegFilename, paired := pairedJpeg(sourceFilename); paired {
+		archiveRaw(sourceFilename, jpegFilename, f, overwrite)
+		return
+	}
 
-	photo, err := jsonFilePhotoStore.Get(sourceFilename)
+	if overwrite && !dryRun {
+		if photo, err := photoStore.Get(sourceFilename); err == nil {
+			removePhoto(photo)
+		}
+	}
+
+	// the image decoded to create the photo, if it had to be
+	var decoded image.Image
+	photo, err := photoStore.Get(sourceFilename)
+	if err == nil && photo.Hidden {
+		if err := restorePhoto(&photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", sourceFilename, err.Error())
+			return
+		}
+	}
 	if err != nil {
-		photo, err = createPhoto(sourceFilename)
+		photo, decoded, err = createPhoto(sourceFilename, f)
 		if err != nil {
 			log.Printf("Can't create photo from %s : %s\n", sourceFilename, err.Error())
 			return
 		}
-		err = jsonFilePhotoStore.Add(photo)
-		if err != nil {
+		if err := addPhoto(&photo); err != nil {
 			log.Printf("Can't store photo from %s : %s\n", sourceFilename, err.Error())
 			return
 		}
 	}
+	if photo.DuplicateOf != "" {
+		// its objects are the ones of its original
+		return
+	}
 
-	exists, err := s3Manager.ExistsImage(sourceFilename)
+	exists, err := s3Manager.ExistsImage(photo.Key())
 	if err != nil {
 		log.Printf(err.Error())
 		return
 	}
-	if !exists {
-		f, err := os.Open(imageSourceFolderPath + sourceFilename)
-		if err != nil {
-			log.Println(err)
-		} else {
-			s3Manager.UploadImage(f, sourceFilename)
+	if !exists || overwrite {
+		report.record("image upload", sourceFilename)
+		if !dryRun {
+			f.Seek(0, io.SeekStart)
+			s3Manager.UploadImage(f, photo.Key(), util.MimeType(photo.Format, sourceFilename))
 		}
 	}
 
-	exists, err = s3Manager.ExistsThumb(sourceFilename)
+	if photo.MediaType == util.MediaVideo && !util.PosterAvailable() {
+		return
+	}
+	todo, err := renditionsToRender(photo, overwrite)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Printf("Can't check renditions of %s : %s\n", sourceFilename, err.Error())
 		return
 	}
-	if !exists {
-		f, err := os.Open(imageSourceFolderPath + sourceFilename)
+	if dryRun {
+		return
+	}
+	stored := photo
+	if len(todo) > 0 {
+		photo.PerceptualHash, err = uploadRenditions(photo, f, decoded, todo)
 		if err != nil {
-			log.Println(err)
-		} else {
-			log.Printf("Resizing %s", sourceFilename)
-			buf := bytes.NewBuffer(make([]byte, 0))
-			resizeImg(f, buf, 0, 162)
-			log.Printf("%s successfully resized", sourceFilename)
-			r := bytes.NewReader(buf.Bytes())
-			s3Manager.UploadThumb(r, sourceFilename)
+			log.Printf("Can't upload renditions of %s : %s\n", sourceFilename, err.Error())
+			return
 		}
 	}
+	photo.RenditionsHash = util.RenditionsHash(renditions)
+	if photo != stored {
+		if err := photoStore.Update(photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", sourceFilename, err.Error())
+		}
+	}
+}
 
-	exists, err = s3Manager.ExistsMedium(sourceFilename)
+// archiveRaw uploads a RAW file shot along a JPEG, which stands for both in
+// the gallery, and links it to the photo of the JPEG.
+func archiveRaw(rawFilename string, jpegFilename string, f *os.File, overwrite bool) {
+	exists, err := s3Manager.ExistsImage(rawFilename)
 	if err != nil {
 		log.Printf(err.Error())
 		return
 	}
-	if !exists {
-		f, err := os.Open(imageSourceFolderPath + sourceFilename)
+	if !exists || overwrite {
+		report.record("raw upload", rawFilename)
+		if !dryRun {
+			s3Manager.UploadImage(f, rawFilename, util.MimeType(util.FormatRaw, rawFilename))
+		}
+	}
+	if dryRun {
+		return
+	}
+	if photo, err := photoStore.Get(jpegFilename); err == nil && photo.RawFilename != rawFilename {
+		photo.RawFilename = rawFilename
+		if err := photoStore.Update(photo); err != nil {
+			log.Printf("Can't store photo %s : %s\n", jpegFilename, err.Error())
+		}
+	}
+}
+
+// addPhoto stores a new photo: in place of the stored photo having the same
+// content whose source file is gone, as it was moved or renamed, or as the
+// duplicate of the stored photo having the same content, if there is one.
+func addPhoto(photo *util.Photo) error {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+	if moved, found := util.FindMoved(photoStore.GetByHash(photo.Hash), photo.Hash, sourceExists); found {
+		report.record("moved photo", photo.Filename)
+		log.Printf("%s moved to %s", moved.Filename, photo.Filename)
+		photo.MovedFrom(moved)
+		if dryRun {
+			// not moved: syncDeletions must not report it removed
+			dryRunMoved[moved.Filename] = true
+			return nil
+		}
+		if err := util.MovePhoto(photoStore, moved, *photo); err != nil {
+			return err
+		}
+		linkLiveSibling(*photo)
+		return nil
+	}
+	if original, found := util.FindOriginal(photoStore.GetByHash(photo.Hash), photo.Hash, photo.Filename); found {
+		photo.DuplicateOf = original.Filename
+		photo.ObjectKey = original.Key()
+		photo.RenditionsHash = original.RenditionsHash
+		report.record("duplicate", photo.Filename)
+		log.Printf("%s is a duplicate of %s", photo.Filename, original.Filename)
+	} else {
+		report.record("new photo", photo.Filename)
+	}
+	if dryRun {
+		return nil
+	}
+	if err := photoStore.Add(*photo); err != nil {
+		return err
+	}
+	linkLiveSibling(*photo)
+	return nil
+}
+
+// removePhoto forgets a photo, its first duplicate taking its place.
+func removePhoto(photo util.Photo) {
+	addMutex.Lock()
+	defer addMutex.Unlock()
+	if err := photoStore.Remove(photo); err != nil {
+		log.Printf("Can't remove photo %s : %s\n", photo.Filename, err.Error())
+		return
+	}
+	if err := util.PromoteDuplicate(photoStore, photo); err != nil {
+		log.Printf("Can't promote a duplicate of %s : %s\n", photo.Filename, err.Error())
+	}
+}
+
+// linkLiveSibling adds the other half of the Live Photo photo, stored
+// alone before photo arrived, to the group of photo. Only the halves without
+// content identifier need it, as their group is named after both files.
+func linkLiveSibling(photo util.Photo) {
+	if photo.GroupID != util.LiveNameGroupID(photo.Filename) {
+		return
+	}
+	for _, name := range util.LiveSiblings(photo.Filename, photo.MediaType == util.MediaVideo) {
+		sibling, err := photoStore.Get(name)
+		if err != nil || sibling.GroupID != "" {
+			continue
+		}
+		sibling.GroupID = photo.GroupID
+		if err := photoStore.Update(sibling); err != nil {
+			log.Printf("Can't store photo %s : %s\n", name, err.Error())
+		}
+	}
+}
+
+// pairedJpeg returns the JPEG shot along the RAW file sourceFilename, if
+// there is one.
+func pairedJpeg(sourceFilename string) (string, bool) {
+	if !util.IsRawName(sourceFilename) {
+		return "", false
+	}
+	return firstExisting(util.JpegSiblings(sourceFilename))
+}
+
+// firstExisting returns the first of the names found in the image source
+// folder.
+func firstExisting(names []string) (string, bool) {
+	for _, name := range names {
+		if sourceExists(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sourceContentID returns the content identifier of the source file name,
+// and whether it is in the image source folder.
+func sourceContentID(name string) (string, bool) {
+	f, err := os.Open(sourcePath(name))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	return util.ContentIdentifier(f), true
+}
+
+// sourceExists tells whether name is in the image source folder.
+func sourceExists(name string) bool {
+	_, err := os.Stat(sourcePath(name))
+	return err == nil
+}
+
+// renditionsToRender returns the profiles whose rendition of photo is
+// missing or stale (rendered with other settings). The hashes stored on the
+// S3 objects are only checked when the profiles changed since the photo was
+// last handled, renditions without hash matching the legacy thumb and
+// medium.
+func renditionsToRender(photo util.Photo, overwrite bool) ([]util.RenditionProfile, error) {
+	upToDate := photo.RenditionsHash == util.RenditionsHash(renditions)
+	todo := []util.RenditionProfile{}
+	for _, profile := range renditions {
+		exists, err := s3Manager.ExistsRendition(photo.Key(), profile)
 		if err != nil {
-			log.Println(err)
-		} else {
-			log.Printf("Resizing %s", sourceFilename)
-			buf := bytes.NewBuffer(make([]byte, 0))
-			resizeImg(f, buf, 0, 768)
-			log.Printf("%s successfully resized", sourceFilename)
-			r := bytes.NewReader(buf.Bytes())
-			s3Manager.UploadMedium(r, sourceFilename)
+			return nil, err
+		}
+		if !exists || overwrite {
+			report.record("missing "+profile.Name, photo.Filename)
+			todo = append(todo, profile)
+			continue
+		}
+		if upToDate {
+			continue
+		}
+		hash, err := s3Manager.RenditionHash(photo.Key(), profile)
+		if err != nil {
+			return nil, err
+		}
+		if !profile.MatchesHash(hash) {
+			report.record("stale "+profile.Name, photo.Filename)
+			todo = append(todo, profile)
 		}
 	}
+	return todo, nil
 }
 
-func createPhoto(sourceFilename string) (util.Photo, error) {
-	photo := util.Photo{}
+// decodeSource decodes the image the renditions of photo are rendered from:
+// the poster frame of videos, the preview embedded in RAW files, or else the
+// source file, rotated and flipped according to its orientation. decoded,
+// if not nil, is that image as createPhoto already decoded it.
+func decodeSource(photo util.Photo, f *os.File, decoded image.Image) (image.Image, error) {
+	switch {
+	case decoded != nil:
+		return decoded, nil
+	case photo.MediaType == util.MediaVideo:
+		// a frame at 1s, past the fade in, or in the middle of short videos
+		at := time.Second
+		if duration := time.Duration(photo.Duration * float64(time.Second)); duration < 2*time.Second {
+			at = duration / 2
+		}
+		return util.VideoPoster(f.Name(), at)
+	case photo.Format == util.FormatRaw:
+		preview, err := util.RawPreview(f)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := util.DecodeImage(bytes.NewReader(preview))
+		if err != nil {
+			return nil, err
+		}
+		return util.ApplyOrientation(img, photo.Orientation), nil
+	default:
+		f.Seek(0, io.SeekStart)
+		img, _, err := util.DecodeImage(f)
+		if err != nil {
+			return nil, err
+		}
+		return util.ApplyOrientation(img, photo.Orientation), nil
+	}
+}
 
-	f, err := os.Open(imageSourceFolderPath + sourceFilename)
+// uploadRenditions decodes the source of photo once, unless decoded already
+// is, and uploads its renditions for the profiles. It returns the perceptual
+// hash of the photo.
+func uploadRenditions(photo util.Photo, f *os.File, decoded image.Image, profiles []util.RenditionProfile) (string, error) {
+	log.Printf("Resizing %s", photo.Filename)
+	img, err := decodeSource(photo, f, decoded)
 	if err != nil {
-		log.Println(err)
-		return photo, err
+		return "", err
+	}
+	rendered, perceptualHash, err := util.RenderImage(img, profiles)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("%s successfully resized", photo.Filename)
+	for i, profile := range profiles {
+		if _, err := s3Manager.UploadRendition(bytes.NewReader(rendered[i]), photo.Key(), profile); err != nil {
+			return "", err
+		}
 	}
+	return perceptualHash, nil
+}
+
+// changeReport counts the changes made by a back run, or the ones it would
+// make with -dryrun.
+type changeReport struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (cr *changeReport) record(change string, sourceFilename string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.counts[change]++
+	if dryRun {
+		log.Printf("Would handle %s : %s", change, sourceFilename)
+	}
+}
 
-	x, err := exif.Decode(f)
+func (cr *changeReport) print() {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	changes := []string{}
+	for change := range cr.counts {
+		changes = append(changes, change)
+	}
+	sort.Strings(changes)
+	if len(changes) == 0 {
+		log.Println("Nothing to do, everything is up to date")
+	}
+	for _, change := range changes {
+		log.Printf("%s : %d", change, cr.counts[change])
+	}
+}
+
+// sourcePath returns the path of a source file from its slash separated
+// name relative to the image source folder.
+func sourcePath(sourceFilename string) string {
+	return filepath.Join(imageSourceFolderPath, filepath.FromSlash(sourceFilename))
+}
+
+// createVideo creates the photo of a video from the metadata of its movie
+// atom.
+func createVideo(sourceFilename string, f *os.File, info os.FileInfo, format string) (util.Photo, error) {
+	photo := util.Photo{Filename: sourceFilename, FileSize: info.Size(), Format: format}
+	meta, err := util.ParseVideo(f)
 	if err != nil {
-		log.Println(sourceFilename, err)
 		return photo, err
 	}
-	tm, _ := x.DateTime()
-	if false {
-		log.Println("Taken: ", tm)
+	tm, timeZoneSource, err := meta.VideoDateTime(defaultLocation)
+	dateSource := util.DateFromVideo
+	if err != nil {
+		f.Seek(0, io.SeekStart)
+		tm, timeZoneSource, dateSource = util.CaptureDate(nil, f, sourceFilename, info.ModTime(), defaultLocation)
+		log.Printf("%s : date taken from %s", sourceFilename, dateSource)
 	}
-
-	albumTime := time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, time.UTC)
-	photo.AlbumDateTime = int(albumTime.Unix())
-	photo.DateTime = int(tm.Unix())
-	photo.Filename = sourceFilename
+	photo.SetDateTime(tm, timeZoneSource, dateSource)
+	photo.SetVideoMetadata(meta)
+	photo.GroupID = util.GroupID(sourceFilename, true, meta.ContentIdentifier, "", sourceContentID)
 	return photo, nil
 }
 
-func resizeImg(r io.Reader, w io.Writer, width uint, height uint) {
-	// decode jpeg into image.Image
-	img, err := jpeg.Decode(r)
+// readPhoto creates a photo from its source file.
+func readPhoto(sourceFilename string) (util.Photo, error) {
+	f, err := os.Open(sourcePath(sourceFilename))
 	if err != nil {
-		log.Println(err)
-		return
+		return util.Photo{}, err
+	}
+	defer f.Close()
+	photo, _, err := createPhoto(sourceFilename, f)
+	return photo, err
+}
+
+// createPhoto reads the photo of a source file. HEIC images being decoded
+// to get their dimensions, it also returns the decoded image, for the
+// renditions to be rendered from.
+func createPhoto(sourceFilename string, f *os.File) (util.Photo, image.Image, error) {
+	photo := util.Photo{}
+
+	info, err := f.Stat()
+	if err != nil {
+		return photo, nil, err
+	}
+
+	head := make([]byte, 512)
+	f.Seek(0, io.SeekStart)
+	n, _ := io.ReadFull(f, head)
+	format := util.DetectFormat(head[:n])
+	if util.IsRaw(sourceFilename, head[:n]) {
+		format = util.FormatRaw
+	}
+	if format == "" {
+		return photo, nil, errors.New("unsupported content type " + http.DetectContentType(head[:n]))
+	}
+	if !util.DecoderAvailable(format) {
+		return photo, nil, errors.New("no decoder available for " + format)
+	}
+	f.Seek(0, io.SeekStart)
+	hash, err := util.HashFile(f)
+	if err != nil {
+		return photo, nil, err
+	}
+	if util.IsVideo(format) {
+		photo, err = createVideo(sourceFilename, f, info, format)
+		photo.Hash, photo.ObjectKey = hash, util.ContentKey(hash, sourceFilename)
+		return photo, nil, err
 	}
 
-	// resize using Lanczos resampling
-	// and preserve aspect ratio
-	m := resize.Resize(width, height, img, resize.Lanczos3)
+	x, err := util.DecodeExif(f, format)
+	if err != nil {
+		log.Println(sourceFilename, err)
+		x = nil
+	}
+	f.Seek(0, io.SeekStart)
+	tm, timeZoneSource, dateSource := util.CaptureDate(x, f, sourceFilename, info.ModTime(), defaultLocation)
+	if dateSource != util.DateFromExif {
+		log.Printf("%s : date taken from %s", sourceFilename, dateSource)
+	}
 
-	// write new image to file
-	jpeg.Encode(w, m, nil)
+	photo.SetDateTime(tm, timeZoneSource, dateSource)
+	photo.Filename = sourceFilename
+	photo.FileSize = info.Size()
+	photo.Format = format
+	photo.Hash, photo.ObjectKey = hash, util.ContentKey(hash, sourceFilename)
+	contentID, burstID := "", ""
+	if x != nil {
+		photo.SetExifMetadata(x)
+		contentID, burstID = util.AppleIdentifiers(x)
+	}
+	photo.GroupID = util.GroupID(sourceFilename, false, contentID, burstID, sourceContentID)
+	if format == util.FormatHeic {
+		// the decoder already applies the rotation and mirroring
+		photo.Orientation = 0
+	}
+	if format == util.FormatJpeg {
+		photo.RawFilename, _ = firstExisting(util.RawSiblings(sourceFilename))
+	}
+
+	var config image.Config
+	var decoded image.Image
+	switch format {
+	case util.FormatRaw:
+		var preview []byte
+		if preview, err = util.RawPreview(f); err == nil {
+			config, _, err = image.DecodeConfig(bytes.NewReader(preview))
+		}
+	case util.FormatHeic:
+		f.Seek(0, io.SeekStart)
+		if decoded, _, err = util.DecodeImage(f); err == nil {
+			bounds := decoded.Bounds()
+			config.Width, config.Height = bounds.Dx(), bounds.Dy()
+		}
+	default:
+		f.Seek(0, io.SeekStart)
+		config, err = util.DecodeConfig(f, format)
+	}
+	if err == nil {
+		photo.Width, photo.Height = util.OrientedSize(config.Width, config.Height, photo.Orientation)
+	}
+	return photo, decoded, nil
 }
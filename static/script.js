@@ -17,11 +17,45 @@ angular.module('Album', [])
                     $scope.images[albumTimestamp] = [];
                 }
                 var albumImages = $scope.images[albumTimestamp];
-                albumImages[albumImages.length] = data[key];
+                albumImages[albumImages.length] = withGroup(data[key]);
             }
             $scope.loadingStatus = false;
         }
 
+        //a group is shown as its primary photo: the video of a Live Photo
+        //plays from its image, the other frames of a burst are expanded on demand
+        function withGroup(image) {
+            var members = image.Members || [];
+            if (members.length == 0) {
+                return image;
+            }
+            if (image.GroupID.indexOf("live-") == 0) {
+                var video = _.find(members, function (member) {
+                    return member.MediaType == 'video';
+                });
+                if (video) {
+                    image.LiveUrl = video.Urls.original;
+                }
+            } else {
+                image.BurstCount = members.length + 1;
+            }
+            return image;
+        }
+
+        $scope.toggleGroup = function (albumID, image) {
+            var albumImages = $scope.images[albumID];
+            var index = albumImages.indexOf(image);
+            if (image.expanded) {
+                albumImages.splice(index + 1, image.Members.length);
+            } else {
+                var members = $.map(image.Members, function (member) {
+                    return $.extend({member: true}, member);
+                });
+                Array.prototype.splice.apply(albumImages, [index + 1, 0].concat(members));
+            }
+            image.expanded = !image.expanded;
+        };
+
         function handleAlbumsLoaded(data, status) {
             $scope.allAlbums = data;
             $scope.loadMoreAlbums();
@@ -49,15 +83,12 @@ angular.module('Album', [])
             $.each(albums, function () {
                 $scope.loadedAlbums.push(this);
             });
-            $scope.fetchImages(albums);
+            $scope.fetchImages($.map(albums, function (album) {
+                return album.ID;
+            }));
             //$(window).scroll(scrollWatcher);
         };
 
-        $scope.getAlbumTitle = function (album) {
-            var date = new Date(album * 1000);
-            return date.format("dd mmmm yyyy");
-        }
-
         $scope.fetchAlbums();
     });
 
@@ -73,11 +104,24 @@ function scrollWatcher() {
 
 var currentImg;
 $(document).on("click", ".images img", function () {
-    $("#myModalLabel").html($(this).parent().parent().prev().html());
+    $("#myModalLabel").html($(this).closest('.images').prev().html());
     $('#imagepreview').attr('src', '');
+    $('#imagepreviewpicture source').remove();
     $('#imagemodal').modal('show');
     currentImg = this;
+    //modern formats first, the browser picks the first it supports
+    $.each($(this).data('mediumsources') || [], function () {
+        $('<source>').attr('type', this.Type).attr('srcset', this.Srcset).insertBefore('#imagepreview');
+    });
     $('#imagepreview').attr('src', $(this).data('mediumurl'));
+    $('#playlive').toggle(!!$(this).data('liveurl'));
+    if ($(this).data('mediatype') == 'video') {
+        $('#imagepreviewpicture').hide();
+        $('#videopreview').attr('poster', $(this).data('mediumurl')).attr('src', $(this).data('originalurl')).show();
+    } else {
+        stopVideo();
+        $('#imagepreviewpicture').show();
+    }
     $('#imagemodal .modal-dialog').css("width", (this.width)*4.74 + 30);
     var element_to_scroll_to = document.getElementById($(this).data('filename'));
     element_to_scroll_to.scrollIntoView();
@@ -86,6 +130,32 @@ $(document).on("click", ".images img", function () {
 $(document).on('shown.bs.modal', '#imagemodal', function () {
 });
 
+//plays the video of a Live Photo over its image, then shows the image back
+$(document).on("click", "#playlive", function () {
+    $('#imagepreviewpicture').hide();
+    $('#videopreview').attr('src', $(currentImg).data('liveurl')).show().get(0).play();
+});
+
+//media events don't bubble, the handler can't be delegated
+$(function () {
+    $('#videopreview').on("ended", function () {
+        if ($(currentImg).data('liveurl')) {
+            stopVideo();
+            $('#imagepreviewpicture').show();
+        }
+    });
+});
+
+$(document).on('hidden.bs.modal', '#imagemodal', function () {
+    stopVideo();
+});
+
+function stopVideo() {
+    var video = $('#videopreview');
+    video.get(0).pause();
+    video.removeAttr('src').removeAttr('poster').hide();
+}
+
 $(document).keydown(function(e){
     if (e.keyCode == 37) {
         slide(false);
@@ -99,19 +169,19 @@ $(document).keydown(function(e){
 function slide(right) {
     if($('#imagemodal').attr("aria-hidden") == "false") {
         if(right) {
-            var next = $(currentImg).parent().next();
+            var next = $(currentImg).closest('li').next();
             if(next.length == 0) {
                 //next album
-                next = $(currentImg).parent().parent().parent().next();
+                next = $(currentImg).closest('.album').next();
             }
             if(next.length != 0) {
                 next.find("img").first().trigger("click");
             }
         } else {
-            var prev = $(currentImg).parent().prev();
+            var prev = $(currentImg).closest('li').prev();
             if(prev.length == 0) {
                 //prev album
-                prev = $(currentImg).parent().parent().parent().prev();
+                prev = $(currentImg).closest('.album').prev();
             }
             if(prev.length != 0) {
                 prev.find("img").last().trigger("click");
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const albumTitleLayout = "02 January 2006"
+
+// Album is a group of photos shown together in the gallery.
+type Album struct {
+	ID            string
+	Title         string
+	Folder        string `json:",omitempty"`
+	DateTime      int
+	Count         int
+	CoverFilename string
+	CoverKey      string  `json:"-"`
+	CoverUrl      string  `json:",omitempty"`
+	Photos        []Photo `json:"-"`
+}
+
+// albumID builds a stable album ID from the kind of album and a key
+// identifying it within that kind.
+func albumID(kind string, key string) string {
+	sum := sha1.Sum([]byte(key))
+	return kind + "-" + hex.EncodeToString(sum[:6])
+}
+
+// FolderAlbums groups photos by the folder they are in, relative to the
+// source folder, most recent album first. Photos at the root of the source
+// folder are in no folder album.
+func FolderAlbums(photos []Photo) []Album {
+	byFolder := map[string][]Photo{}
+	for _, photo := range photos {
+		folder := path.Dir(photo.Filename)
+		if folder == "." {
+			continue
+		}
+		byFolder[folder] = append(byFolder[folder], photo)
+	}
+
+	albums := []Album{}
+	for folder, folderPhotos := range byFolder {
+		album := newAlbum(albumID("folder", folder), path.Base(folder), folderPhotos)
+		album.Folder = folder
+		albums = append(albums, album)
+	}
+	sortAlbums(albums)
+	return albums
+}
+
+// DateAlbums groups photos by the local date they were taken, most recent
+// album first. The ID of a date album is its AlbumDateTime.
+func DateAlbums(photos []Photo) []Album {
+	byDate := map[int][]Photo{}
+	for _, photo := range photos {
+		byDate[photo.AlbumDateTime] = append(byDate[photo.AlbumDateTime], photo)
+	}
+
+	albums := []Album{}
+	for albumDateTime, datePhotos := range byDate {
+		title := time.Unix(int64(albumDateTime), 0).UTC().Format(albumTitleLayout)
+		albums = append(albums, newAlbum(strconv.Itoa(albumDateTime), title, datePhotos))
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		return albums[i].Photos[0].AlbumDateTime > albums[j].Photos[0].AlbumDateTime
+	})
+	return albums
+}
+
+// newAlbum builds an album from its photos. The cover is the oldest photo
+// having renditions and the album DateTime is the one of the most recent
+// photo. An album without renditions has no CoverKey.
+func newAlbum(id string, title string, photos []Photo) Album {
+	sort.Sort(ByDateTime(photos))
+	album := Album{
+		ID:            id,
+		Title:         title,
+		DateTime:      photos[0].DateTime,
+		Count:         len(photos),
+		CoverFilename: photos[len(photos)-1].Filename,
+		Photos:        photos,
+	}
+	for i := len(photos) - 1; i >= 0; i-- {
+		if photos[i].HasRenditions() {
+			album.CoverFilename, album.CoverKey = photos[i].Filename, photos[i].Key()
+			break
+		}
+	}
+	return album
+}
+
+func sortAlbums(albums []Album) {
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].DateTime != albums[j].DateTime {
+			return albums[i].DateTime > albums[j].DateTime
+		}
+		return albums[i].ID < albums[j].ID
+	})
+}
+
+// FindAlbum returns the album with the given ID.
+func FindAlbum(albums []Album, id string) (Album, bool) {
+	for _, album := range albums {
+		if album.ID == id {
+			return album, true
+		}
+	}
+	return Album{}, false
+}
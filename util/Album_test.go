@@ -0,0 +1,73 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestFolderAlbums(t *testing.T) {
+	photos := []Photo{
+		{Filename: "root.jpg", DateTime: 10},
+		{Filename: "2024/Trip to Lisbon/b.jpg", DateTime: 30},
+		{Filename: "2024/Trip to Lisbon/a.jpg", DateTime: 20},
+		{Filename: "2023/Paris/c.jpg", DateTime: 5},
+	}
+
+	albums := FolderAlbums(photos)
+	if len(albums) != 2 {
+		t.Fatal("2 albums expected", albums)
+	}
+	lisbon := albums[0]
+	if lisbon.Title != "Trip to Lisbon" || lisbon.Folder != "2024/Trip to Lisbon" || lisbon.Count != 2 {
+		t.Error("wrong album", lisbon)
+	}
+	if lisbon.CoverFilename != "2024/Trip to Lisbon/a.jpg" || lisbon.DateTime != 30 {
+		t.Error("cover should be the oldest photo, date the most recent", lisbon)
+	}
+	if albums[1].Title != "Paris" {
+		t.Error("albums should be sorted most recent first", albums)
+	}
+
+	again := FolderAlbums(photos[1:])
+	if again[0].ID != lisbon.ID {
+		t.Error("album IDs should be stable")
+	}
+	if album, found := FindAlbum(albums, lisbon.ID); !found || album.Title != lisbon.Title {
+		t.Error("album not found by ID")
+	}
+}
+
+func TestAlbumCoverWithoutPoster(t *testing.T) {
+	photos := []Photo{
+		{Filename: "a/new.jpg", DateTime: 30},
+		{Filename: "a/poster.mp4", DateTime: 20, MediaType: MediaVideo, RenditionsHash: "hash"},
+		{Filename: "a/old.mp4", DateTime: 10, MediaType: MediaVideo},
+		{Filename: "b/only.mp4", DateTime: 5, MediaType: MediaVideo},
+	}
+
+	albums := FolderAlbums(photos)
+	if albums[0].CoverFilename != "a/poster.mp4" || albums[0].CoverKey != "a/poster.mp4" {
+		t.Error("cover should be the oldest photo having a poster", albums[0])
+	}
+	if albums[1].CoverKey != "" {
+		t.Error("album without poster should have no cover", albums[1])
+	}
+}
+
+func TestDateAlbums(t *testing.T) {
+	photos := []Photo{
+		{Filename: "a.jpg", AlbumDateTime: 1431561600, DateTime: 1431561600 + 3600},
+		{Filename: "b.jpg", AlbumDateTime: 1431561600, DateTime: 1431561600 + 7200},
+		{Filename: "c.jpg", AlbumDateTime: 1431475200, DateTime: 1431475200 + 3600},
+	}
+
+	albums := DateAlbums(photos)
+	if len(albums) != 2 {
+		t.Fatal("2 albums expected", albums)
+	}
+	if albums[0].ID != "1431561600" || albums[0].Title != "14 May 2015" || albums[0].Count != 2 {
+		t.Error("wrong album", albums[0])
+	}
+	if albums[1].Title != "13 May 2015" {
+		t.Error("wrong album", albums[1])
+	}
+}
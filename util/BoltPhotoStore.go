@@ -0,0 +1,315 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	photosBucket     = []byte("photos")
+	albumIndexBucket = []byte("albumDateTime")
+	hashIndexBucket  = []byte("hash")
+)
+
+// BoltPhotoStore keeps photos in an embedded bbolt database. Every Add,
+// Update and Remove is its own transaction, synced to disk before returning.
+//
+// The database file is only held open while operations are running, so a
+// front and a back process can share it: they take turns on the file lock.
+type BoltPhotoStore struct {
+	FileName string
+	Timeout  time.Duration
+	db       *bolt.DB
+	refs     int
+	mutex    sync.Mutex
+}
+
+func (bps *BoltPhotoStore) acquire() (*bolt.DB, error) {
+	bps.mutex.Lock()
+	defer bps.mutex.Unlock()
+	if bps.db == nil {
+		timeout := bps.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		db, err := bolt.Open(bps.FileName, os.FileMode(0644), &bolt.Options{Timeout: timeout})
+		if err != nil {
+			return nil, err
+		}
+		bps.db = db
+	}
+	bps.refs++
+	return bps.db, nil
+}
+
+func (bps *BoltPhotoStore) release() {
+	bps.mutex.Lock()
+	defer bps.mutex.Unlock()
+	bps.refs--
+	if bps.refs == 0 {
+		bps.db.Close()
+		bps.db = nil
+	}
+}
+
+func (bps *BoltPhotoStore) view(fn func(tx *bolt.Tx) error) error {
+	db, err := bps.acquire()
+	if err != nil {
+		return err
+	}
+	defer bps.release()
+	return db.View(fn)
+}
+
+func (bps *BoltPhotoStore) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bps.acquire()
+	if err != nil {
+		return err
+	}
+	defer bps.release()
+	return db.Update(fn)
+}
+
+func albumIndexKey(albumDateTime int, fileName string) []byte {
+	key := make([]byte, 8, 8+len(fileName))
+	binary.BigEndian.PutUint64(key, uint64(int64(albumDateTime)))
+	return append(key, fileName...)
+}
+
+func hashIndexKey(hash string, fileName string) []byte {
+	return []byte(hash + "/" + fileName)
+}
+
+func (bps *BoltPhotoStore) Init() error {
+	if bps.FileName == "" {
+		return errors.New("The filename is empty")
+	}
+	return bps.update(func(tx *bolt.Tx) error {
+		photosB, err := tx.CreateBucketIfNotExists(photosBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(albumIndexBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(hashIndexBucket) != nil {
+			return nil
+		}
+		// databases of older versions have no hash index yet
+		hashIndex, err := tx.CreateBucket(hashIndexBucket)
+		if err != nil {
+			return err
+		}
+		return photosB.ForEach(func(k, v []byte) error {
+			photo := Photo{}
+			if err := json.Unmarshal(v, &photo); err != nil {
+				return err
+			}
+			if photo.Hash == "" {
+				return nil
+			}
+			return hashIndex.Put(hashIndexKey(photo.Hash, photo.Filename), []byte{})
+		})
+	})
+}
+
+func (bps *BoltPhotoStore) Erase() error {
+	err := bps.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{photosBucket, albumIndexBucket, hashIndexBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bps.Init()
+}
+
+// Flush is a no-op: every write is already committed to disk.
+func (bps *BoltPhotoStore) Flush() error {
+	return nil
+}
+
+// Reload is a no-op: reads always go to the database.
+func (bps *BoltPhotoStore) Reload() error {
+	return nil
+}
+
+func (bps *BoltPhotoStore) Get(fileName string) (Photo, error) {
+	photo := Photo{}
+	err := bps.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(photosBucket).Get([]byte(fileName))
+		if value == nil {
+			return errors.New("No photo found for filename " + fileName)
+		}
+		return json.Unmarshal(value, &photo)
+	})
+	return photo, err
+}
+
+// GetAll, GetByAlbum and GetByHash log the photos which can't be decoded
+// and leave them out, as they would if the database can't be opened.
+func (bps *BoltPhotoStore) GetAll() []Photo {
+	photos := []Photo{}
+	err := bps.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(photosBucket).ForEach(func(k, v []byte) error {
+			if photo, ok := decodePhoto(k, v); ok {
+				photos = append(photos, photo)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Println("Can't read photos :", err)
+	}
+	return photos
+}
+
+// decodePhoto decodes the stored photo named fileName, logging failures.
+func decodePhoto(fileName []byte, value []byte) (Photo, bool) {
+	photo := Photo{}
+	if err := json.Unmarshal(value, &photo); err != nil {
+		log.Printf("Can't decode photo %s : %s\n", fileName, err.Error())
+		return photo, false
+	}
+	return photo, true
+}
+
+func (bps *BoltPhotoStore) GetByAlbum(albumDateTime int) []Photo {
+	photos := []Photo{}
+	err := bps.view(func(tx *bolt.Tx) error {
+		prefix := albumIndexKey(albumDateTime, "")
+		photosB := tx.Bucket(photosBucket)
+		c := tx.Bucket(albumIndexBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			fileName := k[len(prefix):]
+			if photo, ok := decodePhoto(fileName, photosB.Get(fileName)); ok {
+				photos = append(photos, photo)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Can't read photos :", err)
+	}
+	return photos
+}
+
+func (bps *BoltPhotoStore) GetByHash(hash string) []Photo {
+	photos := []Photo{}
+	if hash == "" {
+		return photos
+	}
+	err := bps.view(func(tx *bolt.Tx) error {
+		prefix := hashIndexKey(hash, "")
+		photosB := tx.Bucket(photosBucket)
+		c := tx.Bucket(hashIndexBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			fileName := k[len(prefix):]
+			if photo, ok := decodePhoto(fileName, photosB.Get(fileName)); ok {
+				photos = append(photos, photo)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Can't read photos :", err)
+	}
+	return photos
+}
+
+// indexHash puts photo in the hash index, or deletes it from the index if
+// remove is true.
+func indexHash(tx *bolt.Tx, photo Photo, remove bool) error {
+	if photo.Hash == "" {
+		return nil
+	}
+	key := hashIndexKey(photo.Hash, photo.Filename)
+	if remove {
+		return tx.Bucket(hashIndexBucket).Delete(key)
+	}
+	return tx.Bucket(hashIndexBucket).Put(key, []byte{})
+}
+
+func (bps *BoltPhotoStore) Add(photo Photo) error {
+	value, err := json.Marshal(photo)
+	if err != nil {
+		return err
+	}
+	return bps.update(func(tx *bolt.Tx) error {
+		photosB := tx.Bucket(photosBucket)
+		if photosB.Get([]byte(photo.Filename)) != nil {
+			return errors.New("Filename already exists")
+		}
+		if err := photosB.Put([]byte(photo.Filename), value); err != nil {
+			return err
+		}
+		if err := indexHash(tx, photo, false); err != nil {
+			return err
+		}
+		return tx.Bucket(albumIndexBucket).Put(albumIndexKey(photo.AlbumDateTime, photo.Filename), []byte{})
+	})
+}
+
+func (bps *BoltPhotoStore) Update(photo Photo) error {
+	value, err := json.Marshal(photo)
+	if err != nil {
+		return err
+	}
+	return bps.update(func(tx *bolt.Tx) error {
+		photosB := tx.Bucket(photosBucket)
+		previous := photosB.Get([]byte(photo.Filename))
+		if previous == nil {
+			return errors.New("No photo found for filename " + photo.Filename)
+		}
+		stored := Photo{}
+		if err := json.Unmarshal(previous, &stored); err != nil {
+			return err
+		}
+		index := tx.Bucket(albumIndexBucket)
+		if err := index.Delete(albumIndexKey(stored.AlbumDateTime, stored.Filename)); err != nil {
+			return err
+		}
+		if err := indexHash(tx, stored, true); err != nil {
+			return err
+		}
+		if err := indexHash(tx, photo, false); err != nil {
+			return err
+		}
+		if err := photosB.Put([]byte(photo.Filename), value); err != nil {
+			return err
+		}
+		return index.Put(albumIndexKey(photo.AlbumDateTime, photo.Filename), []byte{})
+	})
+}
+
+func (bps *BoltPhotoStore) Remove(photo Photo) error {
+	return bps.update(func(tx *bolt.Tx) error {
+		photosB := tx.Bucket(photosBucket)
+		value := photosB.Get([]byte(photo.Filename))
+		if value == nil {
+			return errors.New("Photo not in list")
+		}
+		stored := Photo{}
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return err
+		}
+		if err := photosB.Delete([]byte(photo.Filename)); err != nil {
+			return err
+		}
+		if err := indexHash(tx, stored, true); err != nil {
+			return err
+		}
+		return tx.Bucket(albumIndexBucket).Delete(albumIndexKey(stored.AlbumDateTime, stored.Filename))
+	})
+}
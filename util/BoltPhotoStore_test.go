@@ -0,0 +1,155 @@
+package util
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"testing"
+)
+
+const boltFilename string = "/tmp/testboltPhotoStore.db"
+
+func TestBoltAddGetRemove(t *testing.T) {
+	os.Remove(boltFilename)
+	defer os.Remove(boltFilename)
+
+	photo1 := photoFixture("filename1")
+	photo2 := photoFixture("filename2")
+	boltPhotoStore := BoltPhotoStore{FileName: boltFilename}
+	err := boltPhotoStore.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = boltPhotoStore.Add(photo1)
+	if err != nil {
+		t.Error(err)
+	}
+	err = boltPhotoStore.Add(photo1)
+	if err == nil {
+		t.Error("Filename already in, should not be added")
+	}
+	boltPhotoStore.Add(photo2)
+
+	photo, err := boltPhotoStore.Get(photo1.Filename)
+	if err != nil || photo != photo1 {
+		t.Error("Not the same photos")
+	}
+
+	err = boltPhotoStore.Remove(photo1)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err = boltPhotoStore.Get(photo1.Filename); err == nil {
+		t.Error("Photo1 should be removed")
+	}
+	if len(boltPhotoStore.GetAll()) != 1 {
+		t.Error("Photo2 should remain in collection")
+	}
+}
+
+func TestBoltGetByAlbumAndReopen(t *testing.T) {
+	os.Remove(boltFilename)
+	defer os.Remove(boltFilename)
+
+	photo1 := photoFixture("filename1")
+	photo2 := photoFixture("filename2")
+	photo2.AlbumDateTime = 86400
+	boltPhotoStore := BoltPhotoStore{FileName: boltFilename}
+	boltPhotoStore.Init()
+	boltPhotoStore.Add(photo1)
+	boltPhotoStore.Add(photo2)
+
+	boltPhotoStore = BoltPhotoStore{FileName: boltFilename}
+	err := boltPhotoStore.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	album := boltPhotoStore.GetByAlbum(86400)
+	if len(album) != 1 || album[0] != photo2 {
+		t.Error("album index should only return photo2")
+	}
+
+	err = boltPhotoStore.Erase()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(boltPhotoStore.GetAll()) != 0 || len(boltPhotoStore.GetByAlbum(1)) != 0 {
+		t.Error("photos should be erased")
+	}
+}
+
+func TestBoltUpdate(t *testing.T) {
+	os.Remove(boltFilename)
+	defer os.Remove(boltFilename)
+
+	boltPhotoStore := BoltPhotoStore{FileName: boltFilename}
+	boltPhotoStore.Init()
+	boltPhotoStore.Add(photoFixture("filename1"))
+
+	updated := photoFixture("filename1")
+	updated.AlbumDateTime = 86400
+	err := boltPhotoStore.Update(updated)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(boltPhotoStore.GetByAlbum(1)) != 0 || len(boltPhotoStore.GetByAlbum(86400)) != 1 {
+		t.Error("album index should be updated")
+	}
+}
+
+func TestBoltGetByHash(t *testing.T) {
+	os.Remove(boltFilename)
+	defer os.Remove(boltFilename)
+
+	boltPhotoStore := BoltPhotoStore{FileName: boltFilename}
+	boltPhotoStore.Init()
+	boltPhotoStore.Add(duplicateFixture("a.jpg", "h1", ""))
+	boltPhotoStore.Add(duplicateFixture("b.jpg", "h1", "a.jpg"))
+	boltPhotoStore.Add(photoFixture("old.jpg"))
+	if photos := boltPhotoStore.GetByHash("h1"); len(photos) != 2 || photos[0].Filename != "a.jpg" || photos[1].Filename != "b.jpg" {
+		t.Error("a.jpg and b.jpg have the hash h1", photos)
+	}
+	if photos := boltPhotoStore.GetByHash(""); len(photos) != 0 {
+		t.Error("photos without hash are not indexed", photos)
+	}
+
+	boltPhotoStore.Update(duplicateFixture("b.jpg", "h2", ""))
+	boltPhotoStore.Remove(duplicateFixture("a.jpg", "h1", ""))
+	if photos := boltPhotoStore.GetByHash("h1"); len(photos) != 0 {
+		t.Error("the hash index should follow updates and removals", photos)
+	}
+
+	// databases of older versions have no hash index
+	boltPhotoStore.update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(hashIndexBucket)
+	})
+	boltPhotoStore = BoltPhotoStore{FileName: boltFilename}
+	if err := boltPhotoStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if photos := boltPhotoStore.GetByHash("h2"); len(photos) != 1 || photos[0].Filename != "b.jpg" {
+		t.Error("the hash index should be built on init", photos)
+	}
+}
+
+func TestBoltUndecodablePhoto(t *testing.T) {
+	os.Remove(boltFilename)
+	defer os.Remove(boltFilename)
+
+	boltPhotoStore := BoltPhotoStore{FileName: boltFilename}
+	boltPhotoStore.Init()
+	boltPhotoStore.Add(photoFixture("a.jpg"))
+	boltPhotoStore.Add(photoFixture("c.jpg"))
+	boltPhotoStore.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(photosBucket).Put([]byte("b.jpg"), []byte("garbage")); err != nil {
+			return err
+		}
+		return tx.Bucket(albumIndexBucket).Put(albumIndexKey(1, "b.jpg"), []byte{})
+	})
+	if photos := boltPhotoStore.GetAll(); len(photos) != 2 || photos[1].Filename != "c.jpg" {
+		t.Error("only the undecodable photo should be left out", photos)
+	}
+	if photos := boltPhotoStore.GetByAlbum(1); len(photos) != 2 {
+		t.Error("only the undecodable photo should be left out of its album", photos)
+	}
+}
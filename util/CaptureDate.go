@@ -0,0 +1,104 @@
+package util
+
+import (
+	"errors"
+	"github.com/rwcarlsen/goexif/exif"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Capture date sources, from the most to the least reliable.
+const (
+	DateFromExif     = "exif"
+	DateFromXmp      = "xmp"
+	DateFromFilename = "filename"
+	DateFromFolder   = "folder"
+	DateFromModTime  = "mtime"
+)
+
+// maxXmpSearch is how far in a file XMP packets are looked for.
+const maxXmpSearch = 4 * 1024 * 1024
+
+var (
+	// a date, with an optional time, not surrounded by other digits or
+	// letters, as in hex names: IMG_20230514_123456,
+	// Screenshot_2023-05-14-12-34-56, IMG-20230514-WA0001
+	dateInNameRegexp = regexp.MustCompile(`(?:^|[^0-9A-Za-z])((?:19|20)\d{2})[-_. /]?(0[1-9]|1[0-2])[-_. /]?(0[1-9]|[12]\d|3[01])(?:[-_ T.]?([01]\d|2[0-3])[-_.:h]?([0-5]\d)[-_.:m]?([0-5]\d)\d{0,3})?(?:[^0-9A-Za-z]|$)`)
+	xmpDateRegexp    = regexp.MustCompile(`(?:exif:DateTimeOriginal|xmp:CreateDate|photoshop:DateCreated)(?:\s*=\s*"([^"]+)"|>([^<]+)<)`)
+	xmpDateLayouts   = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04Z07:00"}
+	xmpLocalLayouts  = []string{"2006-01-02T15:04:05", "2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02"}
+)
+
+// CaptureDate finds when a file was taken, trying in turn its EXIF date
+// (x may be nil), its XMP packet, a date in its name, a date in its folder
+// names and finally its modification time. name is the slash separated path
+// of the file relative to the source folder.
+//
+// It returns the date in the time zone it was taken in, where that time zone
+// comes from and where the date comes from.
+func CaptureDate(x *exif.Exif, content io.Reader, name string, modTime time.Time, defaultLocation *time.Location) (t time.Time, timeZoneSource string, dateSource string) {
+	if x != nil {
+		if t, timeZoneSource, err := ExifDateTime(x, defaultLocation); err == nil {
+			return t, timeZoneSource, DateFromExif
+		}
+	}
+	if content != nil {
+		if t, timeZoneSource, err := XmpDateTime(content, defaultLocation); err == nil {
+			return t, timeZoneSource, DateFromXmp
+		}
+	}
+	if t, found := DateInName(path.Base(name), defaultLocation); found {
+		return t, TimeZoneFromDefault, DateFromFilename
+	}
+	if folder := path.Dir(name); folder != "." {
+		if t, found := DateInName(folder, defaultLocation); found {
+			return t, TimeZoneFromDefault, DateFromFolder
+		}
+	}
+	return modTime.In(defaultLocation), TimeZoneFromDefault, DateFromModTime
+}
+
+// XmpDateTime returns the creation date found in the XMP packet of a file.
+func XmpDateTime(content io.Reader, defaultLocation *time.Location) (time.Time, string, error) {
+	head, err := ioutil.ReadAll(io.LimitReader(content, maxXmpSearch))
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	for _, match := range xmpDateRegexp.FindAllSubmatch(head, -1) {
+		value := string(match[1]) + string(match[2])
+		for _, layout := range xmpDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, TimeZoneFromOffset, nil
+			}
+		}
+		for _, layout := range xmpLocalLayouts {
+			if t, err := time.ParseInLocation(layout, value, defaultLocation); err == nil {
+				return t, TimeZoneFromDefault, nil
+			}
+		}
+	}
+	return time.Time{}, "", errors.New("no XMP date found")
+}
+
+// DateInName looks for a date, with an optional time, in a file or folder
+// name such as IMG_20230514_123456.jpg or "2023-05-14 Lisbon".
+func DateInName(name string, location *time.Location) (time.Time, bool) {
+	match := dateInNameRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+	values := make([]int, 6)
+	for i, value := range match[1:] {
+		values[i], _ = strconv.Atoi(value)
+	}
+	t := time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0, location)
+	if t.Day() != values[2] {
+		// 31 of a 30 days month
+		return time.Time{}, false
+	}
+	return t, true
+}
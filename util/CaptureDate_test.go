@@ -0,0 +1,67 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateInName(t *testing.T) {
+	expected := map[string]time.Time{
+		"IMG_20230514_123456.jpg":             time.Date(2023, 5, 14, 12, 34, 56, 0, time.UTC),
+		"PXL_20230514_123456789.jpg":          time.Date(2023, 5, 14, 12, 34, 56, 0, time.UTC),
+		"Screenshot_2023-05-14-12-34-56.png":  time.Date(2023, 5, 14, 12, 34, 56, 0, time.UTC),
+		"IMG-20230514-WA0001.jpg":             time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC),
+		"2023-05-14 12.34.56.jpg":             time.Date(2023, 5, 14, 12, 34, 56, 0, time.UTC),
+		"2023/05/14":                          time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC),
+		"2024/2023-05-14 Trip to Lisbon":      time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC),
+		"Scan 19991231.tif":                   time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC),
+		"WhatsApp Image 2023-05-14 at 12.jpg": time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC),
+	}
+	for name, date := range expected {
+		found, ok := DateInName(name, time.UTC)
+		if !ok || !found.Equal(date) {
+			t.Error(name, found, ok)
+		}
+	}
+	for _, name := range []string{"IMG_1234.jpg", "DSC120230514.jpg", "IMG_a20190312f.jpg", "3fa20190312b9c.jpg", "2023-02-31.jpg", "2024/Trip to Lisbon"} {
+		if found, ok := DateInName(name, time.UTC); ok {
+			t.Error(name, "should have no date", found)
+		}
+	}
+}
+
+func TestXmpDateTime(t *testing.T) {
+	xmp := `<x:xmpmeta><rdf:Description xmp:CreateDate="2023-05-14T12:34:56+02:00"/></x:xmpmeta>`
+	date, timeZoneSource, err := XmpDateTime(strings.NewReader("\xff\xd8garbage"+xmp), time.UTC)
+	if err != nil || timeZoneSource != TimeZoneFromOffset || date.Unix() != time.Date(2023, 5, 14, 10, 34, 56, 0, time.UTC).Unix() {
+		t.Error(date, timeZoneSource, err)
+	}
+
+	xmp = `<photoshop:DateCreated>2023-05-14</photoshop:DateCreated>`
+	date, timeZoneSource, err = XmpDateTime(strings.NewReader(xmp), time.UTC)
+	if err != nil || timeZoneSource != TimeZoneFromDefault || !date.Equal(time.Date(2023, 5, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Error(date, timeZoneSource, err)
+	}
+
+	if _, _, err = XmpDateTime(strings.NewReader("no xmp"), time.UTC); err == nil {
+		t.Error("no date expected")
+	}
+}
+
+func TestCaptureDate(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	date, _, dateSource := CaptureDate(nil, strings.NewReader(""), "2023-05-14 Lisbon/IMG_20230515_101010.jpg", modTime, time.UTC)
+	if dateSource != DateFromFilename || date.Day() != 15 {
+		t.Error("date should come from the file name", date, dateSource)
+	}
+	date, _, dateSource = CaptureDate(nil, strings.NewReader(""), "2023-05-14 Lisbon/scan.jpg", modTime, time.UTC)
+	if dateSource != DateFromFolder || date.Day() != 14 {
+		t.Error("date should come from the folder name", date, dateSource)
+	}
+	date, _, dateSource = CaptureDate(nil, strings.NewReader(""), "Lisbon/scan.jpg", modTime, time.UTC)
+	if dateSource != DateFromModTime || !date.Equal(modTime) {
+		t.Error("date should be the modification time", date, dateSource)
+	}
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"errors"
+)
+
+// Deletion policies, telling what happens to the photos whose source file
+// was removed.
+const (
+	// DeleteKeep leaves the photo in the gallery.
+	DeleteKeep string = "keep"
+	// DeleteHide hides the photo, and keeps its objects. It shows again if
+	// its source file comes back.
+	DeleteHide string = "hide"
+	// DeleteTrash removes the photo, and moves its objects to the trash
+	// folder.
+	DeleteTrash string = "trash"
+	// DeletePurge removes the photo, and deletes its objects.
+	DeletePurge string = "purge"
+)
+
+// ParseDeletePolicy checks a deletion policy, "" meaning DeleteHide.
+func ParseDeletePolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return DeleteHide, nil
+	case DeleteKeep, DeleteHide, DeleteTrash, DeletePurge:
+		return s, nil
+	}
+	return "", errors.New("unknown deletion policy " + s)
+}
+
+// MissingSources returns the photos, hidden ones included, whose source
+// file is not among names, the slash separated paths of the source files.
+func MissingSources(photos []Photo, names []string) []Photo {
+	present := presentNames(names)
+	missing := []Photo{}
+	for _, photo := range photos {
+		if !present[photo.Filename] {
+			missing = append(missing, photo)
+		}
+	}
+	return missing
+}
+
+// MissingRaws returns the RAW files of the photos whose JPEG is among names
+// but which are not.
+func MissingRaws(photos []Photo, names []string) []string {
+	present := presentNames(names)
+	missing := []string{}
+	for _, photo := range photos {
+		if photo.RawFilename != "" && present[photo.Filename] && !present[photo.RawFilename] {
+			missing = append(missing, photo.RawFilename)
+		}
+	}
+	return missing
+}
+
+func presentNames(names []string) map[string]bool {
+	present := map[string]bool{}
+	for _, name := range names {
+		present[name] = true
+	}
+	return present
+}
+
+// WithoutHidden returns the photos which are not hidden.
+func WithoutHidden(photos []Photo) []Photo {
+	visible := []Photo{}
+	for _, photo := range photos {
+		if !photo.Hidden {
+			visible = append(visible, photo)
+		}
+	}
+	return visible
+}
+
+// KeyShared tells whether a photo other than photo stores its objects under
+// the same key, as duplicates do.
+func KeyShared(photos []Photo, photo Photo) bool {
+	for _, other := range photos {
+		if other.Filename != photo.Filename && other.Key() == photo.Key() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseDeletePolicy(t *testing.T) {
+	for s, want := range map[string]string{"": DeleteHide, "keep": DeleteKeep, "trash": DeleteTrash, "purge": DeletePurge} {
+		if policy, err := ParseDeletePolicy(s); err != nil || policy != want {
+			t.Errorf("ParseDeletePolicy(%q) = %q, %v", s, policy, err)
+		}
+	}
+	if _, err := ParseDeletePolicy("delete"); err == nil {
+		t.Error("delete is not a policy")
+	}
+}
+
+func TestMissingSources(t *testing.T) {
+	hidden := photoFixture("hidden.jpg")
+	hidden.Hidden = true
+	photos := []Photo{photoFixture("a.jpg"), photoFixture("sub/b.jpg"), hidden}
+
+	missing := MissingSources(photos, []string{"a.jpg"})
+	if len(missing) != 2 || missing[0].Filename != "sub/b.jpg" || missing[1].Filename != "hidden.jpg" {
+		t.Error("sub/b.jpg and hidden.jpg should be missing", missing)
+	}
+	if visible := WithoutHidden(photos); len(visible) != 2 {
+		t.Error("hidden.jpg should be left out", visible)
+	}
+	hidden.Hash = "h1"
+	if _, found := FindOriginal([]Photo{hidden}, "h1", "c.jpg"); found {
+		t.Error("a hidden photo is no original")
+	}
+}
+
+func TestMissingRaws(t *testing.T) {
+	kept, gone, both := photoFixture("a.jpg"), photoFixture("b.jpg"), photoFixture("c.jpg")
+	kept.RawFilename, gone.RawFilename, both.RawFilename = "a.CR2", "b.CR2", "c.CR2"
+	missing := MissingRaws([]Photo{kept, gone, both, photoFixture("d.jpg")}, []string{"a.jpg", "a.CR2", "b.jpg"})
+	if len(missing) != 1 || missing[0] != "b.CR2" {
+		t.Error("only b.CR2 should be missing, c.CR2 goes with its JPEG", missing)
+	}
+}
+
+func TestKeyShared(t *testing.T) {
+	original := photoFixture("a.jpg")
+	original.ObjectKey = "h1.jpg"
+	duplicate := duplicateFixture("b.jpg", "h1", "a.jpg")
+	duplicate.ObjectKey = "h1.jpg"
+	legacy := photoFixture("c.jpg")
+
+	if !KeyShared([]Photo{original, duplicate, legacy}, original) {
+		t.Error("the objects of a.jpg are shared with b.jpg")
+	}
+	if KeyShared([]Photo{original, legacy}, original) || KeyShared([]Photo{original, legacy}, legacy) {
+		t.Error("the objects of a.jpg and c.jpg are not shared")
+	}
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HashFile returns the hex encoded SHA-256 of the content of r.
+func HashFile(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentKey returns the key the objects of a source file are stored under
+// in S3: the hash of its content, with the extension of its name in lower
+// case. Copies of a file share their objects.
+func ContentKey(hash string, name string) string {
+	return hash + strings.ToLower(path.Ext(name))
+}
+
+// FindOriginal returns the photo having the given hash, other than the
+// photo named name, which is neither a duplicate nor hidden.
+func FindOriginal(photos []Photo, hash string, name string) (Photo, bool) {
+	if hash == "" {
+		return Photo{}, false
+	}
+	for _, photo := range photos {
+		if photo.Hash == hash && photo.Filename != name && photo.DuplicateOf == "" && !photo.Hidden {
+			return photo, true
+		}
+	}
+	return Photo{}, false
+}
+
+// WithoutDuplicates returns the photos which are not the duplicate of
+// another one.
+func WithoutDuplicates(photos []Photo) []Photo {
+	originals := []Photo{}
+	for _, photo := range photos {
+		if photo.DuplicateOf == "" {
+			originals = append(originals, photo)
+		}
+	}
+	return originals
+}
+
+// DuplicateSets returns the copies of each content found more than once,
+// the original first then its duplicates by filename, hidden photos left
+// out. The sets are sorted by the filename of their original.
+func DuplicateSets(photos []Photo) [][]Photo {
+	copies := map[string][]Photo{}
+	for _, photo := range photos {
+		if photo.Hash != "" && !photo.Hidden {
+			copies[photo.Hash] = append(copies[photo.Hash], photo)
+		}
+	}
+	sets := [][]Photo{}
+	for _, set := range copies {
+		if len(set) < 2 {
+			continue
+		}
+		sort.Slice(set, func(i, j int) bool {
+			if (set[i].DuplicateOf == "") != (set[j].DuplicateOf == "") {
+				return set[i].DuplicateOf == ""
+			}
+			return set[i].Filename < set[j].Filename
+		})
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i][0].Filename < sets[j][0].Filename
+	})
+	return sets
+}
+
+// PromoteDuplicate makes the first duplicate of removed, by filename, the
+// original of the other duplicates, once removed is no longer stored.
+func PromoteDuplicate(store PhotoStore, removed Photo) error {
+	duplicates := []Photo{}
+	for _, photo := range store.GetAll() {
+		if photo.DuplicateOf == removed.Filename {
+			duplicates = append(duplicates, photo)
+		}
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Filename < duplicates[j].Filename
+	})
+	original := duplicates[0].Filename
+	for i, photo := range duplicates {
+		photo.DuplicateOf = original
+		if i == 0 {
+			photo.DuplicateOf = ""
+		}
+		if err := store.Update(photo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
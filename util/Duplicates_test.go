@@ -0,0 +1,81 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func duplicateFixture(filename string, hash string, duplicateOf string) Photo {
+	photo := photoFixture(filename)
+	photo.Hash, photo.DuplicateOf = hash, duplicateOf
+	return photo
+}
+
+func TestHashFile(t *testing.T) {
+	hash, err := HashFile(strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Error("wrong SHA-256", hash)
+	}
+	if key := ContentKey(hash, "2019/IMG_1.JPG"); key != hash+".jpg" {
+		t.Error("wrong content key", key)
+	}
+}
+
+func TestFindOriginal(t *testing.T) {
+	photos := []Photo{
+		duplicateFixture("a.jpg", "h1", ""),
+		duplicateFixture("b.jpg", "h1", "a.jpg"),
+		duplicateFixture("c.jpg", "h2", ""),
+		photoFixture("old.jpg"),
+	}
+	if original, found := FindOriginal(photos, "h1", "d.jpg"); !found || original.Filename != "a.jpg" {
+		t.Error("d.jpg should be a duplicate of a.jpg", original)
+	}
+	if _, found := FindOriginal(photos, "h2", "c.jpg"); found {
+		t.Error("a photo is not its own duplicate")
+	}
+	if _, found := FindOriginal(photos, "", "d.jpg"); found {
+		t.Error("photos without hash have no duplicates")
+	}
+	if originals := WithoutDuplicates(photos); len(originals) != 3 {
+		t.Error("b.jpg should be left out", originals)
+	}
+}
+
+func TestDuplicateSets(t *testing.T) {
+	a := duplicateFixture("z/a.jpg", "h1", "")
+	b := duplicateFixture("b.jpg", "h1", "z/a.jpg")
+	c := duplicateFixture("c.jpg", "h1", "z/a.jpg")
+	d := duplicateFixture("d.jpg", "h2", "")
+	e := duplicateFixture("e.jpg", "h3", "")
+	f := duplicateFixture("f.jpg", "h3", "e.jpg")
+
+	sets := DuplicateSets([]Photo{c, d, f, b, a, e, photoFixture("old.jpg")})
+	want := [][]Photo{{e, f}, {a, b, c}}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("DuplicateSets = %v, want %v", sets, want)
+	}
+}
+
+func TestPromoteDuplicate(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	original := duplicateFixture("a.jpg", "h1", "")
+	jsonFilePhotoStore.Add(original)
+	jsonFilePhotoStore.Add(duplicateFixture("c.jpg", "h1", "a.jpg"))
+	jsonFilePhotoStore.Add(duplicateFixture("b.jpg", "h1", "a.jpg"))
+
+	jsonFilePhotoStore.Remove(original)
+	if err := PromoteDuplicate(&jsonFilePhotoStore, original); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := jsonFilePhotoStore.Get("b.jpg"); b.DuplicateOf != "" {
+		t.Error("b.jpg should be the new original", b)
+	}
+	if c, _ := jsonFilePhotoStore.Get("c.jpg"); c.DuplicateOf != "b.jpg" {
+		t.Error("c.jpg should be a duplicate of b.jpg", c)
+	}
+}
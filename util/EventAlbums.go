@@ -0,0 +1,78 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const earthRadiusKm = 6371.0
+
+// EventAlbums groups photos into events. A new event starts when the time
+// between two consecutive photos is longer than maxGap, or when a geotagged
+// photo is more than maxDistanceKm (0 to ignore locations) away from the
+// last geotagged photo of the event, photos without location in between.
+//
+// The ID of an event comes from its first photo, so it survives re-ingesting
+// the same photos, unless an earlier photo joins the event.
+func EventAlbums(photos []Photo, maxGap time.Duration, maxDistanceKm float64) []Album {
+	sorted := make([]Photo, len(photos))
+	copy(sorted, photos)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].DateTime != sorted[j].DateTime {
+			return sorted[i].DateTime < sorted[j].DateTime
+		}
+		return sorted[i].Filename < sorted[j].Filename
+	})
+
+	albums := []Album{}
+	start := 0
+	// the last geotagged photo of the current event, if any
+	located := Photo{}
+	for i := 1; i <= len(sorted); i++ {
+		if sorted[i-1].HasLocation() {
+			located = sorted[i-1]
+		}
+		if i < len(sorted) && !startsEvent(sorted[i-1], located, sorted[i], maxGap, maxDistanceKm) {
+			continue
+		}
+		first, last := sorted[start], sorted[i-1]
+		eventPhotos := make([]Photo, i-start)
+		copy(eventPhotos, sorted[start:i])
+		albums = append(albums, newAlbum(albumID("event", first.Filename), eventTitle(first, last), eventPhotos))
+		start = i
+		located = Photo{}
+	}
+	sortAlbums(albums)
+	return albums
+}
+
+func startsEvent(previous Photo, located Photo, photo Photo, maxGap time.Duration, maxDistanceKm float64) bool {
+	if time.Duration(photo.DateTime-previous.DateTime)*time.Second > maxGap {
+		return true
+	}
+	if maxDistanceKm <= 0 || !located.HasLocation() || !photo.HasLocation() {
+		return false
+	}
+	return distanceKm(located, photo) > maxDistanceKm
+}
+
+func eventTitle(first Photo, last Photo) string {
+	firstDay := first.LocalTime().Format(albumTitleLayout)
+	lastDay := last.LocalTime().Format(albumTitleLayout)
+	if firstDay == lastDay {
+		return firstDay
+	}
+	return firstDay + " - " + lastDay
+}
+
+// distanceKm returns the great-circle distance between two geotagged
+// photos.
+func distanceKm(a Photo, b Photo) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLong := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
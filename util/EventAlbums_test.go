@@ -0,0 +1,54 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventAlbums(t *testing.T) {
+	hour := 3600
+	photos := []Photo{
+		{Filename: "party2.jpg", DateTime: 23*hour + 3600},
+		{Filename: "party1.jpg", DateTime: 23 * hour},
+		{Filename: "morning.jpg", DateTime: 50 * hour},
+		{Filename: "evening.jpg", DateTime: 60 * hour},
+	}
+
+	albums := EventAlbums(photos, 3*time.Hour, 0)
+	if len(albums) != 3 {
+		t.Fatal("3 events expected", albums)
+	}
+	party := albums[2]
+	if party.Count != 2 || party.CoverFilename != "party1.jpg" {
+		t.Error("the party should be one event across midnight", party)
+	}
+
+	again := EventAlbums(photos[:2], 3*time.Hour, 0)
+	if again[0].ID != party.ID {
+		t.Error("event IDs should be stable")
+	}
+}
+
+func TestEventAlbumsDistance(t *testing.T) {
+	paris := Photo{Filename: "paris.jpg", DateTime: 0, Latitude: 48.8566, Longitude: 2.3522}
+	versailles := Photo{Filename: "versailles.jpg", DateTime: 600, Latitude: 48.8049, Longitude: 2.1204}
+	nowhere := Photo{Filename: "nowhere.jpg", DateTime: 1200}
+	louvre := Photo{Filename: "louvre.jpg", DateTime: 1800, Latitude: 48.8606, Longitude: 2.3376}
+	lyon := Photo{Filename: "lyon.jpg", DateTime: 1800, Latitude: 45.7640, Longitude: 4.8357}
+
+	if len(EventAlbums([]Photo{paris, versailles, nowhere, lyon}, time.Hour, 0)) != 1 {
+		t.Error("locations should be ignored")
+	}
+	albums := EventAlbums([]Photo{paris, versailles, nowhere, louvre}, time.Hour, 50)
+	if len(albums) != 1 {
+		t.Error("photos without location should not split events", albums)
+	}
+	albums = EventAlbums([]Photo{paris, versailles, lyon}, time.Hour, 50)
+	if len(albums) != 2 {
+		t.Error("lyon should be another event", albums)
+	}
+	albums = EventAlbums([]Photo{paris, versailles, nowhere, lyon}, time.Hour, 50)
+	if len(albums) != 2 || albums[0].CoverFilename != "lyon.jpg" || albums[0].Count != 1 {
+		t.Error("a photo without location should not hide the way to lyon", albums)
+	}
+}
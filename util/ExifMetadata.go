@@ -0,0 +1,85 @@
+package util
+
+import (
+	"fmt"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"math"
+	"strings"
+)
+
+// SetExifMetadata fills the camera, exposure and location metadata of the
+// photo from its EXIF block. Missing tags are left empty.
+func (p *Photo) SetExifMetadata(x *exif.Exif) {
+	p.Make = exifString(x, exif.Make)
+	p.Model = exifString(x, exif.Model)
+	p.Lens = exifString(x, exif.LensModel)
+	p.FocalLength = exifFloat(x, exif.FocalLength)
+	p.Aperture = exifFloat(x, exif.FNumber)
+	p.ISO = exifInt(x, exif.ISOSpeedRatings)
+	p.Orientation = exifInt(x, exif.Orientation)
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil {
+			p.ExposureTime = formatExposureTime(num, den)
+		}
+	}
+	if lat, long, err := x.LatLong(); err == nil && finite(lat) && finite(long) {
+		p.Latitude, p.Longitude = lat, long
+	}
+}
+
+// finite tells whether v is neither NaN nor infinite, as 0/0 GPS rationals
+// give, which can't be stored in JSON.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.StringVal {
+		return ""
+	}
+	value, _ := tag.StringVal()
+	return strings.TrimSpace(value)
+}
+
+func exifFloat(x *exif.Exif, name exif.FieldName) float64 {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	switch tag.Format() {
+	case tiff.RatVal:
+		num, den, err := tag.Rat2(0)
+		if err != nil || den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	case tiff.IntVal:
+		value, _ := tag.Int(0)
+		return float64(value)
+	}
+	return 0
+}
+
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil || tag.Format() != tiff.IntVal {
+		return 0
+	}
+	value, _ := tag.Int(0)
+	return value
+}
+
+// formatExposureTime formats an exposure time the way cameras display it:
+// 1/250 below one second, 2.5 above. Broken times (zero or negative) give
+// an empty string.
+func formatExposureTime(num int64, den int64) string {
+	if num <= 0 || den <= 0 {
+		return ""
+	}
+	if num >= den {
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(num)/float64(den)), ".0")
+	}
+	return fmt.Sprintf("1/%d", (den+num/2)/num)
+}
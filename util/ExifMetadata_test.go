@@ -0,0 +1,76 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/rwcarlsen/goexif/exif"
+	"testing"
+)
+
+func TestFormatExposureTime(t *testing.T) {
+	expected := map[[2]int64]string{
+		{1, 250}:   "1/250",
+		{10, 2500}: "1/250",
+		{1, 3}:     "1/3",
+		{2, 1}:     "2",
+		{5, 2}:     "2.5",
+		{0, 250}:   "",
+		{1, 0}:     "",
+		{-1, 250}:  "",
+	}
+	for rat, exposureTime := range expected {
+		if formatted := formatExposureTime(rat[0], rat[1]); formatted != exposureTime {
+			t.Error(rat, formatted)
+		}
+	}
+}
+
+// gpsTiff returns a TIFF block whose GPS IFD holds a latitude and a
+// longitude, each made of 3 rationals: degrees, minutes and seconds.
+func gpsTiff(lat [3][2]uint32, long [3][2]uint32) []byte {
+	const gpsOffset = 8 + 2 + 12 + 4
+	const dataOffset = gpsOffset + 2 + 4*12 + 4
+	buf := bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	for _, v := range []interface{}{
+		uint32(8), uint16(1), // IFD offset, 1 entry
+		uint16(0x8825), uint16(4), uint32(1), uint32(gpsOffset),
+		uint32(0), // no next IFD
+		uint16(4), // GPS IFD, 4 entries
+		uint16(1), uint16(2), uint32(2), []byte("N\x00\x00\x00"),
+		uint16(2), uint16(5), uint32(3), uint32(dataOffset),
+		uint16(3), uint16(2), uint32(2), []byte("E\x00\x00\x00"),
+		uint16(4), uint16(5), uint32(3), uint32(dataOffset + 24),
+		uint32(0),
+		lat, long,
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func TestSetExifMetadataLocation(t *testing.T) {
+	x, err := exif.Decode(bytes.NewReader(gpsTiff([3][2]uint32{{48, 1}, {30, 1}, {0, 1}}, [3][2]uint32{{2, 1}, {15, 1}, {0, 1}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := Photo{}
+	photo.SetExifMetadata(x)
+	if photo.Latitude != 48.5 || photo.Longitude != 2.25 {
+		t.Error("wrong location", photo.Latitude, photo.Longitude)
+	}
+
+	x, err = exif.Decode(bytes.NewReader(gpsTiff([3][2]uint32{{0, 0}, {0, 0}, {0, 0}}, [3][2]uint32{{2, 1}, {0, 1}, {0, 1}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo = Photo{}
+	photo.SetExifMetadata(x)
+	if photo.HasLocation() {
+		t.Error("0/0 coordinates should be dropped", photo.Latitude, photo.Longitude)
+	}
+	if _, err := json.Marshal(photo); err != nil {
+		t.Error(err)
+	}
+}
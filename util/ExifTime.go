@@ -0,0 +1,160 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"io"
+	"strings"
+	"time"
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Time zone sources, from the most to the least reliable.
+const (
+	TimeZoneFromOffset  = "offset"
+	TimeZoneFromGPS     = "gps"
+	TimeZoneFromDefault = "default"
+)
+
+// offsetFields are the EXIF 2.31 time offset tags, unknown to goexif.
+var offsetFields = map[uint16]exif.FieldName{
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+}
+
+// ExifDateTime returns when the photo was taken, in the time zone it was
+// taken in, and where that time zone comes from: the EXIF offset tags, the
+// difference with the GPS time or else defaultLocation.
+func ExifDateTime(x *exif.Exif, defaultLocation *time.Location) (time.Time, string, error) {
+	tag, err := x.Get(exif.DateTimeOriginal)
+	if err != nil {
+		tag, err = x.Get(exif.DateTime)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+	}
+	dateStr, err := tag.StringVal()
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	// wall clock time, whatever the zone
+	wall, err := time.ParseInLocation(exifTimeLayout, strings.TrimRight(dateStr, "\x00"), time.UTC)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	loadOffsetFields(x)
+	for _, name := range []exif.FieldName{"OffsetTimeOriginal", "OffsetTime"} {
+		if tag, err := x.Get(name); err == nil {
+			if offsetStr, err := tag.StringVal(); err == nil {
+				if offset, err := parseOffset(offsetStr); err == nil {
+					return inOffset(wall, offset), TimeZoneFromOffset, nil
+				}
+			}
+		}
+	}
+
+	if gpsTime, err := exifGPSTime(x); err == nil {
+		if offset, ok := gpsOffset(wall, gpsTime); ok {
+			return inOffset(wall, offset), TimeZoneFromGPS, nil
+		}
+	}
+
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, defaultLocation), TimeZoneFromDefault, nil
+}
+
+// loadOffsetFields loads the offset tags from the EXIF sub-IFD.
+func loadOffsetFields(x *exif.Exif) {
+	if x.Tiff == nil {
+		return
+	}
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil {
+		return
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return
+	}
+	x.LoadTags(dir, offsetFields, false)
+}
+
+// parseOffset parses an EXIF offset such as "+02:00" into seconds east of
+// UTC.
+func parseOffset(offset string) (int, error) {
+	t, err := time.Parse("-07:00", strings.TrimSpace(strings.TrimRight(offset, "\x00")))
+	if err != nil {
+		return 0, err
+	}
+	_, seconds := t.Zone()
+	return seconds, nil
+}
+
+func inOffset(wall time.Time, offset int) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.FixedZone("", offset))
+}
+
+// exifGPSTime returns the UTC time recorded by the GPS.
+func exifGPSTime(x *exif.Exif) (time.Time, error) {
+	dateTag, err := x.Get(exif.GPSDateStamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	dateStr, err := dateTag.StringVal()
+	if err != nil {
+		return time.Time{}, err
+	}
+	day, err := time.Parse("2006:01:02", strings.TrimRight(dateStr, "\x00"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	timeTag, err := x.Get(exif.GPSTimeStamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	hms, err := parse3Rat(timeTag)
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds := hms[0]*3600 + hms[1]*60 + hms[2]
+	return day.Add(time.Duration(seconds * float64(time.Second))), nil
+}
+
+func parse3Rat(tag *tiff.Tag) ([3]float64, error) {
+	v := [3]float64{}
+	if tag.Count < 3 {
+		return v, errors.New("3 rationals expected")
+	}
+	for i := range v {
+		num, den, err := tag.Rat2(i)
+		if err != nil {
+			return v, err
+		}
+		if den == 0 {
+			return v, errors.New("zero denominator")
+		}
+		v[i] = float64(num) / float64(den)
+	}
+	return v, nil
+}
+
+// gpsOffset deduces the time zone offset from the difference between the
+// wall clock time and the GPS time, rounded to the quarter hour.
+func gpsOffset(wall time.Time, gpsTime time.Time) (int, bool) {
+	diff := wall.Sub(gpsTime)
+	if diff > 14*time.Hour || diff < -12*time.Hour {
+		return 0, false
+	}
+	return int(diff.Round(15*time.Minute) / time.Second), true
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOffset(t *testing.T) {
+	for offsetStr, expected := range map[string]int{"+02:00": 7200, "-05:30": -19800, "+00:00\x00": 0} {
+		offset, err := parseOffset(offsetStr)
+		if err != nil || offset != expected {
+			t.Error(offsetStr, offset, err)
+		}
+	}
+	if _, err := parseOffset("   :  "); err == nil {
+		t.Error("unset offset should not be parsed")
+	}
+}
+
+func TestGpsOffset(t *testing.T) {
+	wall := time.Date(2015, 5, 14, 1, 30, 0, 0, time.UTC)
+	gps := time.Date(2015, 5, 13, 23, 29, 12, 0, time.UTC)
+	offset, ok := gpsOffset(wall, gps)
+	if !ok || offset != 7200 {
+		t.Error("2 hours offset expected", offset)
+	}
+	if _, ok := gpsOffset(wall, gps.Add(-24*time.Hour)); ok {
+		t.Error("GPS time too far from wall clock time")
+	}
+}
+
+func TestSetDateTime(t *testing.T) {
+	photo := Photo{}
+	// 00:30 in Tokyo is still the day before in UTC
+	taken := inOffset(time.Date(2015, 5, 14, 0, 30, 0, 0, time.UTC), 9*3600)
+	photo.SetDateTime(taken, TimeZoneFromOffset, DateFromExif)
+
+	if photo.DateTime != int(taken.Unix()) || photo.TimeZoneOffset != 9*3600 {
+		t.Error("wrong date time", photo)
+	}
+	if photo.AlbumDateTime != int(time.Date(2015, 5, 14, 0, 0, 0, 0, time.UTC).Unix()) {
+		t.Error("album should be the local date", photo)
+	}
+	if photo.LocalTime().Hour() != 0 || photo.LocalTime().Day() != 14 {
+		t.Error("wrong local time", photo.LocalTime())
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// heicDecoder converts HEIC images, applying their rotation and mirroring.
+const heicDecoder = "heif-convert"
+
+type externalEncoder struct {
+	command string
+	args    func(quality int, in string, out string) []string
+}
+
+// externalEncoders encode the formats the standard library can't write.
+var externalEncoders = map[string]externalEncoder{
+	"webp": {"cwebp", func(quality int, in string, out string) []string {
+		return []string{"-quiet", "-q", strconv.Itoa(quality), in, "-o", out}
+	}},
+	"avif": {"avifenc", func(quality int, in string, out string) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}},
+}
+
+// EncoderAvailable tells whether renditions can be encoded in format, which
+// for webp and avif needs cwebp and avifenc in the PATH.
+func EncoderAvailable(format string) bool {
+	encoder, external := externalEncoders[format]
+	if !external {
+		return format == "jpeg" || format == "png"
+	}
+	return commandAvailable(encoder.command)
+}
+
+func commandAvailable(command string) bool {
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+// encodeExternal encodes img with an external encoder, going through
+// temporary files as the encoders don't all read from stdin.
+func encodeExternal(w io.Writer, img image.Image, format string, quality int) error {
+	encoder, found := externalEncoders[format]
+	if !found {
+		return errors.New("no encoder for " + format)
+	}
+	dir, err := ioutil.TempDir("", "gogal")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out."+format)
+	f, err := os.Create(in)
+	if err != nil {
+		return err
+	}
+	pngEncoder := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := pngEncoder.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	output, err := exec.Command(encoder.command, encoder.args(quality, in, out)...).CombinedOutput()
+	if err != nil {
+		return errors.New(encoder.command + " : " + err.Error() + " : " + string(output))
+	}
+	encoded, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer encoded.Close()
+	_, err = io.Copy(w, encoded)
+	return err
+}
+
+// decodeExternal decodes an image the standard library can't read, by
+// converting it to PNG with an external decoder.
+func decodeExternal(r io.Reader, format string) (image.Image, error) {
+	if format != FormatHeic {
+		return nil, errors.New("no decoder for " + format)
+	}
+	dir, err := ioutil.TempDir("", "gogal")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in."+format)
+	out := filepath.Join(dir, "out.png")
+	f, err := os.Create(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	output, err := exec.Command(heicDecoder, in, out).CombinedOutput()
+	if err != nil {
+		return nil, errors.New(heicDecoder + " : " + err.Error() + " : " + string(output))
+	}
+	decoded, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer decoded.Close()
+	return png.Decode(decoded)
+}
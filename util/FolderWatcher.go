@@ -0,0 +1,213 @@
+package util
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FolderWatcher reports the files created, changed, removed or moved under
+// Root, as slash separated paths relative to Root.
+//
+// Filesystem notifications (inotify) trigger a rescan of the tree, which is
+// diffed against the previous one. The tree is also rescanned every
+// RescanInterval, in case notifications are missed or not available, unless
+// RescanInterval is 0.
+type FolderWatcher struct {
+	Root           string
+	RescanInterval time.Duration
+	// Settle is how long a file must stay untouched before it is reported,
+	// so that files still being copied are not picked up half written.
+	Settle  time.Duration
+	Created func(name string)
+	Changed func(name string)
+	// Removed is called once per scan with the files removed, sorted, so
+	// that they can be checked as a whole before being forgotten.
+	Removed func(names []string)
+	// Moved, if set, is called instead of Removed and Created for a file
+	// removed and a file created in the same scan with the same size and
+	// modification time, which moving or renaming a file keeps.
+	Moved func(oldName string, newName string)
+	// Ready, if set, is called once the files already present have been
+	// reported, before watching the tree.
+	Ready   func()
+	files   map[string]fileVersion
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// Run reports every file already present as created, calls Ready, then
+// watches the tree until stop is closed.
+func (fw *FolderWatcher) Run(stop <-chan struct{}) {
+	fw.files = map[string]fileVersion{}
+	fw.watched = map[string]bool{}
+
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("Filesystem notifications unavailable, rescanning only :", err)
+	} else {
+		defer watcher.Close()
+		fw.watcher = watcher
+		events = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	var rescans <-chan time.Time
+	if fw.RescanInterval > 0 {
+		rescan := time.NewTicker(fw.RescanInterval)
+		defer rescan.Stop()
+		rescans = rescan.C
+	}
+	settle := time.NewTimer(fw.Settle)
+	defer settle.Stop()
+	if !fw.scan() {
+		settle.Stop()
+	}
+	if fw.Ready != nil {
+		fw.Ready()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-rescans:
+			if fw.scan() {
+				settle.Reset(fw.Settle)
+			}
+		case <-settle.C:
+			if fw.scan() {
+				settle.Reset(fw.Settle)
+			}
+		case <-events:
+			settle.Reset(fw.Settle)
+		case err := <-watchErrors:
+			log.Println("Folder watcher :", err)
+		}
+	}
+}
+
+// scan walks the tree and reports the differences with the previous scan.
+// It returns true if some files were modified too recently to be reported.
+func (fw *FolderWatcher) scan() (pending bool) {
+	seen := map[string]bool{}
+	created := []string{}
+	settled := time.Now().Add(-fw.Settle)
+	err := filepath.Walk(fw.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == fw.Root {
+				return err
+			}
+			log.Println(err)
+			return nil
+		}
+		if info.IsDir() {
+			if fw.watcher != nil && !fw.watched[path] {
+				if err := fw.watcher.Add(path); err != nil {
+					log.Println("Can't watch", path, ":", err)
+				} else {
+					fw.watched[path] = true
+				}
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(fw.Root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		seen[name] = true
+
+		version := fileVersion{modTime: info.ModTime(), size: info.Size()}
+		previous, known := fw.files[name]
+		if known && previous == version {
+			return nil
+		}
+		if version.modTime.After(settled) {
+			pending = true
+			return nil
+		}
+		fw.files[name] = version
+		if known {
+			fw.Changed(name)
+		} else {
+			created = append(created, name)
+		}
+		return nil
+	})
+	if err != nil {
+		// the whole tree is unreachable (unmounted?), don't report it as removed
+		log.Println("Can't scan", fw.Root, ":", err)
+		return false
+	}
+
+	if len(seen) == 0 && len(fw.files) > 0 {
+		// an empty tree is more likely an unmounted folder than a clean up
+		log.Printf("No files found in %s, not reporting them as removed", fw.Root)
+		return pending
+	}
+	removed := map[string]fileVersion{}
+	for name, version := range fw.files {
+		if !seen[name] {
+			removed[name] = version
+			delete(fw.files, name)
+		}
+	}
+	moves := fw.moves(created, removed)
+	for _, name := range created {
+		if oldName, moved := moves[name]; moved {
+			fw.Moved(oldName, name)
+			delete(removed, oldName)
+		} else {
+			fw.Created(name)
+		}
+	}
+	if len(removed) > 0 {
+		names := []string{}
+		for name := range removed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fw.Removed(names)
+	}
+	for path := range fw.watched {
+		if _, err := os.Stat(path); err != nil {
+			delete(fw.watched, path)
+		}
+	}
+	return pending
+}
+
+// moves pairs the created files with the removed files of the same version,
+// when there is only one of each. It returns the old name of the moved
+// files, by new name.
+func (fw *FolderWatcher) moves(created []string, removed map[string]fileVersion) map[string]string {
+	moves := map[string]string{}
+	if fw.Moved == nil {
+		return moves
+	}
+	removedByVersion := map[fileVersion][]string{}
+	for name, version := range removed {
+		removedByVersion[version] = append(removedByVersion[version], name)
+	}
+	createdByVersion := map[fileVersion][]string{}
+	for _, name := range created {
+		createdByVersion[fw.files[name]] = append(createdByVersion[fw.files[name]], name)
+	}
+	for version, names := range createdByVersion {
+		if len(names) == 1 && len(removedByVersion[version]) == 1 {
+			moves[names[0]] = removedByVersion[version][0]
+		}
+	}
+	return moves
+}
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFolderWatcherScan(t *testing.T) {
+	root, err := ioutil.TempDir("", "folderwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	old := time.Now().Add(-time.Hour)
+	writeFile := func(name string, content string, modTime time.Time) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	var created, changed, removed []string
+	fw := FolderWatcher{
+		Root:    root,
+		Settle:  time.Minute,
+		Created: func(name string) { created = append(created, name) },
+		Changed: func(name string) { changed = append(changed, name) },
+		Removed: func(names []string) { removed = append(removed, names...) },
+		files:   map[string]fileVersion{},
+		watched: map[string]bool{},
+	}
+
+	writeFile("a.jpg", "a", old)
+	writeFile("sub/b.jpg", "b", old)
+	writeFile("c.jpg", "c", time.Now())
+	if !fw.scan() {
+		t.Error("c.jpg should be pending")
+	}
+	if len(created) != 2 || created[0] != "a.jpg" || created[1] != "sub/b.jpg" {
+		t.Error("a.jpg and sub/b.jpg should be created", created)
+	}
+
+	created = nil
+	writeFile("a.jpg", "a2", old.Add(time.Minute))
+	os.Remove(filepath.Join(root, "sub", "b.jpg"))
+	if !fw.scan() {
+		t.Error("c.jpg should still be pending")
+	}
+	if len(created) != 0 || len(changed) != 1 || changed[0] != "a.jpg" || len(removed) != 1 || removed[0] != "sub/b.jpg" {
+		t.Error("a.jpg should be changed and sub/b.jpg removed", created, changed, removed)
+	}
+
+	fw.Root = filepath.Join(root, "missing")
+	removed = nil
+	fw.scan()
+	if len(removed) != 0 {
+		t.Error("files should not be removed when the root is missing", removed)
+	}
+
+	fw.Root = root
+	os.RemoveAll(filepath.Join(root, "a.jpg"))
+	os.RemoveAll(filepath.Join(root, "c.jpg"))
+	fw.scan()
+	if len(removed) != 0 {
+		t.Error("files should not be removed when the root is empty", removed)
+	}
+}
+
+func TestFolderWatcherReady(t *testing.T) {
+	root, err := ioutil.TempDir("", "folderwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	old := time.Now().Add(-time.Hour)
+	path := filepath.Join(root, "a.jpg")
+	ioutil.WriteFile(path, []byte("a"), 0644)
+	os.Chtimes(path, old, old)
+
+	var created []string
+	ready := make(chan []string)
+	stop := make(chan struct{})
+	defer close(stop)
+	fw := FolderWatcher{
+		Root:    root,
+		Settle:  time.Minute,
+		Created: func(name string) { created = append(created, name) },
+		Changed: func(name string) {},
+		Removed: func(names []string) {},
+		Ready:   func() { ready <- created },
+	}
+	go fw.Run(stop)
+	select {
+	case created := <-ready:
+		if len(created) != 1 || created[0] != "a.jpg" {
+			t.Error("a.jpg should be created before Ready", created)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Ready should be called")
+	}
+}
+
+func TestFolderWatcherMoves(t *testing.T) {
+	root, err := ioutil.TempDir("", "folderwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	old := time.Now().Add(-time.Hour)
+	writeFile := func(name string, content string, modTime time.Time) {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	var created, removed, moves []string
+	fw := FolderWatcher{
+		Root:    root,
+		Settle:  time.Minute,
+		Created: func(name string) { created = append(created, name) },
+		Changed: func(name string) {},
+		Removed: func(names []string) { removed = append(removed, names...) },
+		Moved:   func(oldName string, newName string) { moves = append(moves, oldName+" "+newName) },
+		files:   map[string]fileVersion{},
+		watched: map[string]bool{},
+	}
+
+	writeFile("a.jpg", "aaa", old)
+	writeFile("b.jpg", "bb", old)
+	writeFile("twin1.jpg", "t", old)
+	writeFile("twin2.jpg", "t", old)
+	fw.scan()
+
+	created = nil
+	os.Mkdir(filepath.Join(root, "sub"), 0755)
+	for _, name := range []string{"a.jpg", "twin1.jpg", "twin2.jpg"} {
+		os.Rename(filepath.Join(root, name), filepath.Join(root, "sub", name))
+	}
+	os.Remove(filepath.Join(root, "b.jpg"))
+	fw.scan()
+	if len(moves) != 1 || moves[0] != "a.jpg sub/a.jpg" {
+		t.Error("a.jpg should be moved to sub/a.jpg", moves)
+	}
+	// the twins have the same version, they can't be told apart
+	if len(created) != 2 || len(removed) != 3 {
+		t.Error("twins should be created and removed, b.jpg removed", created, removed)
+	}
+}
+
+func TestFolderWatcherWithoutRescan(t *testing.T) {
+	root, err := ioutil.TempDir("", "folderwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	old := time.Now().Add(-time.Hour)
+	ioutil.WriteFile(filepath.Join(root, "a.jpg"), []byte("a"), 0644)
+	os.Chtimes(filepath.Join(root, "a.jpg"), old, old)
+
+	created := make(chan string, 1)
+	stop := make(chan struct{})
+	fw := FolderWatcher{Root: root, Settle: time.Minute, Created: func(name string) { created <- name }}
+	go fw.Run(stop)
+	defer close(stop)
+	select {
+	case name := <-created:
+		if name != "a.jpg" {
+			t.Error("a.jpg should be created", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("files already present should be reported without periodic rescan")
+	}
+}
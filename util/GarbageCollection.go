@@ -0,0 +1,51 @@
+package util
+
+import (
+	"sort"
+	"strings"
+)
+
+// ReferencedKeys returns the S3 keys of the objects of photos: their
+// image, their RAW file and their renditions for profiles.
+func ReferencedKeys(photos []Photo, imagePath string, profiles []RenditionProfile) map[string]bool {
+	referenced := map[string]bool{}
+	for _, photo := range photos {
+		referenced[imagePath+photo.Key()] = true
+		if photo.RawFilename != "" {
+			referenced[imagePath+photo.RawFilename] = true
+		}
+		for _, profile := range profiles {
+			referenced[profile.Key(photo.Key())] = true
+		}
+	}
+	return referenced
+}
+
+// Orphans returns, sorted, the keys of objects which are not referenced,
+// leaving out the ones under trashPath.
+func Orphans(objects map[string]int64, referenced map[string]bool, trashPath string) []string {
+	orphans := []string{}
+	for key := range objects {
+		if referenced[key] || (trashPath != "" && strings.HasPrefix(key, trashPath)) {
+			continue
+		}
+		orphans = append(orphans, key)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// DistinctPrefixes returns the prefixes which don't start with another one,
+// sorted, so that listing them lists each key once.
+func DistinctPrefixes(prefixes []string) []string {
+	sorted := append([]string{}, prefixes...)
+	sort.Strings(sorted)
+	distinct := []string{}
+	for _, prefix := range sorted {
+		if len(distinct) > 0 && strings.HasPrefix(prefix, distinct[len(distinct)-1]) {
+			continue
+		}
+		distinct = append(distinct, prefix)
+	}
+	return distinct
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrphans(t *testing.T) {
+	profiles := []RenditionProfile{
+		{Name: "thumb", Format: "jpeg", Path: "pictures/thumb/"},
+		{Name: "thumb-webp", Format: "webp", Path: "pictures/thumb/"},
+	}
+	content := photoFixture("2019/a.jpg")
+	content.ObjectKey = "h1.jpg"
+	content.RawFilename = "2019/a.cr2"
+	legacy := photoFixture("b.jpg")
+
+	referenced := ReferencedKeys([]Photo{content, legacy}, "pictures/", profiles)
+	objects := map[string]int64{
+		"pictures/h1.jpg":                  1,
+		"pictures/2019/a.cr2":              2,
+		"pictures/thumb/h1.jpg":            3,
+		"pictures/thumb/h1.jpg.webp":       4,
+		"pictures/b.jpg":                   5,
+		"pictures/thumb/b.jpg":             6,
+		"pictures/2019/a.jpg":              7,
+		"pictures/thumb/old.jpg":           8,
+		"pictures/trash/pictures/gone.jpg": 9,
+	}
+	orphans := Orphans(objects, referenced, "pictures/trash/")
+	want := []string{"pictures/2019/a.jpg", "pictures/thumb/old.jpg"}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("Orphans = %v, want %v", orphans, want)
+	}
+}
+
+func TestDistinctPrefixes(t *testing.T) {
+	prefixes := DistinctPrefixes([]string{"pictures/thumb/", "pictures/", "medium/", "pictures/thumb/"})
+	if want := []string{"medium/", "pictures/"}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("DistinctPrefixes = %v, want %v", prefixes, want)
+	}
+}
@@ -0,0 +1,160 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// appleMakerNoteHeader starts the maker notes of iPhones, followed by a
+// version and the byte order.
+var appleMakerNoteHeader = []byte("Apple iOS\x00")
+
+// Tags of the Apple maker notes.
+const (
+	appleBurstUUID         uint16 = 0x000B
+	appleContentIdentifier uint16 = 0x0011
+)
+
+var liveVideoExtensions = []string{".mov"}
+var liveImageExtensions = []string{".heic", ".jpg", ".jpeg"}
+
+// Group is a photo standing for the photos linked to it: the video of a
+// Live Photo, or the other frames of a burst.
+type Group struct {
+	Primary Photo
+	Members []Photo
+}
+
+// AppleIdentifiers returns the content identifier, shared by the image and
+// the video of a Live Photo, and the burst identifier, shared by the frames
+// of a burst, found in the maker notes of iPhone photos.
+func AppleIdentifiers(x *exif.Exif) (contentID string, burstID string) {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil || !bytes.HasPrefix(tag.Val, appleMakerNoteHeader) || len(tag.Val) < 16 {
+		return "", ""
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(tag.Val[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	// offsets are relative to the start of the maker notes
+	r := bytes.NewReader(tag.Val)
+	r.Seek(14, 0)
+	dir, _, err := tiff.DecodeDir(r, order)
+	if err != nil {
+		return "", ""
+	}
+	for _, tag := range dir.Tags {
+		if tag.Format() != tiff.StringVal {
+			continue
+		}
+		value, _ := tag.StringVal()
+		switch tag.Id {
+		case appleContentIdentifier:
+			contentID = value
+		case appleBurstUUID:
+			burstID = value
+		}
+	}
+	return contentID, burstID
+}
+
+// LiveSiblings returns the names the other half of a Live Photo exported
+// as name could have: the video of an image, or the image of a video.
+func LiveSiblings(name string, video bool) []string {
+	if video {
+		return siblings(name, liveImageExtensions)
+	}
+	return siblings(name, liveVideoExtensions)
+}
+
+// ContentIdentifier reads the content identifier of the image or the video
+// of a Live Photo, "" if it has none.
+func ContentIdentifier(rs io.ReadSeeker) string {
+	head := make([]byte, 512)
+	rs.Seek(0, io.SeekStart)
+	n, _ := io.ReadFull(rs, head)
+	format := DetectFormat(head[:n])
+	if IsVideo(format) {
+		meta, err := ParseVideo(rs)
+		if err != nil {
+			return ""
+		}
+		return meta.ContentIdentifier
+	}
+	x, err := DecodeExif(rs, format)
+	if err != nil {
+		return ""
+	}
+	contentID, _ := AppleIdentifiers(x)
+	return contentID
+}
+
+// LiveNameGroupID returns the group of the halves of a Live Photo exported
+// without content identifier, named after them.
+func LiveNameGroupID(name string) string {
+	return "live-" + strings.TrimSuffix(name, path.Ext(name))
+}
+
+// GroupID returns the group of a photo: the Live Photo of its content
+// identifier, or else the burst it was shot in. A photo without identifier
+// is grouped with the other half of a Live Photo exported next to it, if
+// that one has no content identifier either: siblingContentID returns the
+// content identifier of a name and whether it is in the source folder. It
+// returns "" for a photo on its own.
+func GroupID(name string, video bool, contentID string, burstID string, siblingContentID func(name string) (string, bool)) string {
+	if contentID != "" {
+		return "live-" + contentID
+	}
+	if burstID != "" {
+		return "burst-" + burstID
+	}
+	for _, sibling := range LiveSiblings(name, video) {
+		if siblingID, exists := siblingContentID(sibling); exists && siblingID == "" {
+			return LiveNameGroupID(name)
+		}
+	}
+	return ""
+}
+
+// GroupPhotos gathers the photos of the same group, keeping the order of
+// photos. The primary photo of a group is its first image (not video) by
+// date, then by filename, the others are its members sorted the same way.
+func GroupPhotos(photos []Photo) []Group {
+	members := map[string][]Photo{}
+	for _, photo := range photos {
+		if photo.GroupID != "" {
+			members[photo.GroupID] = append(members[photo.GroupID], photo)
+		}
+	}
+	for _, group := range members {
+		sort.SliceStable(group, func(i, j int) bool {
+			if (group[i].MediaType == MediaVideo) != (group[j].MediaType == MediaVideo) {
+				return group[j].MediaType == MediaVideo
+			}
+			if group[i].DateTime != group[j].DateTime {
+				return group[i].DateTime < group[j].DateTime
+			}
+			return group[i].Filename < group[j].Filename
+		})
+	}
+
+	groups := []Group{}
+	for _, photo := range photos {
+		if photo.GroupID == "" {
+			groups = append(groups, Group{Primary: photo})
+			continue
+		}
+		group := members[photo.GroupID]
+		if group[0].Filename == photo.Filename {
+			groups = append(groups, Group{Primary: photo, Members: group[1:]})
+		}
+	}
+	return groups
+}
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rwcarlsen/goexif/exif"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// appleExif builds an EXIF block whose Exif IFD holds Apple maker notes
+// with a content identifier and a burst identifier.
+func appleExif(contentID string, burstID string) []byte {
+	contentID, burstID = contentID+"\x00", burstID+"\x00"
+	makerNote := bytes.Buffer{}
+	makerNote.WriteString("Apple iOS\x00\x00\x01II")
+	const dataOffset = 14 + 2 + 2*12 + 4
+	for _, v := range []interface{}{
+		uint16(2),
+		uint16(appleBurstUUID), uint16(2), uint32(len(burstID)), uint32(dataOffset),
+		uint16(appleContentIdentifier), uint16(2), uint32(len(contentID)), uint32(dataOffset + len(burstID)),
+		uint32(0),
+	} {
+		binary.Write(&makerNote, binary.LittleEndian, v)
+	}
+	makerNote.WriteString(burstID + contentID)
+
+	const ifdSize = 2 + 12 + 4
+	buf := bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	for _, v := range []interface{}{
+		uint32(8),
+		uint16(1), uint16(0x8769), uint16(4), uint32(1), uint32(8 + ifdSize), uint32(0),
+		uint16(1), uint16(0x927C), uint16(7), uint32(makerNote.Len()), uint32(8 + 2*ifdSize), uint32(0),
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.Write(makerNote.Bytes())
+	return buf.Bytes()
+}
+
+func TestAppleIdentifiers(t *testing.T) {
+	x, err := exif.Decode(bytes.NewReader(appleExif("0F1E-LIVE", "9A8B-BURST")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentID, burstID := AppleIdentifiers(x)
+	if contentID != "0F1E-LIVE" || burstID != "9A8B-BURST" {
+		t.Errorf("AppleIdentifiers = %q, %q", contentID, burstID)
+	}
+
+	x, err = exif.Decode(bytes.NewReader(orientationTiff(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentID, burstID := AppleIdentifiers(x); contentID != "" || burstID != "" {
+		t.Errorf("AppleIdentifiers without maker notes = %q, %q", contentID, burstID)
+	}
+}
+
+func TestGroupID(t *testing.T) {
+	files := map[string]string{"2019/IMG_1.HEIC": "", "2019/IMG_1.MOV": "", "2019/IMG_2.JPG": "", "2019/IMG_3.JPG": "ID3", "2019/IMG_3.MOV": "ID3"}
+	siblingContentID := func(name string) (string, bool) {
+		contentID, exists := files[name]
+		return contentID, exists
+	}
+	tests := []struct {
+		name      string
+		video     bool
+		contentID string
+		burstID   string
+		want      string
+	}{
+		{"2019/IMG_1.HEIC", false, "", "", "live-2019/IMG_1"},
+		{"2019/IMG_1.MOV", true, "", "", "live-2019/IMG_1"},
+		{"2019/IMG_1.HEIC", false, "ID1", "", "live-ID1"},
+		{"2019/IMG_3.JPG", false, "ID3", "", "live-ID3"},
+		{"2019/IMG_3.MOV", true, "ID3", "", "live-ID3"},
+		{"2019/IMG_3.JPG", false, "", "", ""},
+		{"2019/IMG_2.JPG", false, "ID", "", "live-ID"},
+		{"2019/IMG_2.JPG", false, "", "BURST", "burst-BURST"},
+		{"2019/IMG_2.JPG", false, "", "", ""},
+	}
+	for _, test := range tests {
+		if got := GroupID(test.name, test.video, test.contentID, test.burstID, siblingContentID); got != test.want {
+			t.Errorf("GroupID(%s, %q, %q) = %q, want %q", test.name, test.contentID, test.burstID, got, test.want)
+		}
+	}
+}
+
+func TestContentIdentifier(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	if contentID := ContentIdentifier(bytes.NewReader(pngWithExif(img, appleExif("0F1E-LIVE", "")))); contentID != "0F1E-LIVE" {
+		t.Errorf("ContentIdentifier = %q", contentID)
+	}
+	if contentID := ContentIdentifier(bytes.NewReader(pngWithExif(img, orientationTiff(1)))); contentID != "" {
+		t.Errorf("ContentIdentifier without maker notes = %q", contentID)
+	}
+}
+
+func TestGroupPhotos(t *testing.T) {
+	live := Photo{Filename: "IMG_1.HEIC", DateTime: 100, GroupID: "live-IMG_1"}
+	liveVideo := Photo{Filename: "IMG_1.MOV", DateTime: 99, GroupID: "live-IMG_1", MediaType: MediaVideo}
+	alone := Photo{Filename: "IMG_2.JPG", DateTime: 50}
+	burst1 := Photo{Filename: "IMG_3.JPG", DateTime: 10, GroupID: "burst-B"}
+	burst2 := Photo{Filename: "IMG_4.JPG", DateTime: 10, GroupID: "burst-B"}
+	burst3 := Photo{Filename: "IMG_5.JPG", DateTime: 11, GroupID: "burst-B"}
+
+	groups := GroupPhotos([]Photo{live, liveVideo, alone, burst3, burst2, burst1})
+	want := []Group{
+		{Primary: live, Members: []Photo{liveVideo}},
+		{Primary: alone},
+		{Primary: burst1, Members: []Photo{burst2, burst3}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("GroupPhotos = %+v, want %+v", groups, want)
+	}
+}
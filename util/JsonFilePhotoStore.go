@@ -1,42 +1,303 @@
 package util
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 )
 
+const defaultCompactThreshold int = 500
+
+// JsonFilePhotoStore keeps photos in memory and persists them in a JSON file.
+//
+// Once the store has been loaded, every Add, Update and Remove is also
+// appended to a journal file next to the JSON file, so that an interrupted
+// run does not lose them. LoadFromFile replays the journal, and StoreToFile
+// compacts it into the JSON file.
+//
+// The journal is only opened on the first change, so a process which only
+// reads the store (the front) never rewrites the journal another process is
+// appending to.
 type JsonFilePhotoStore struct {
 	FileName string
-	photos   []Photo
-	mutex    sync.Mutex
+	// CompactThreshold is the number of journal entries after which the
+	// journal is compacted into the JSON file. Defaults to 500.
+	CompactThreshold int
+	photos           []Photo
+	hashIndex        map[string][]Photo
+	mutex            sync.Mutex
+	journal          *os.File
+	journalEntries   int
+	journalTorn      bool
+	loaded           bool
+	loadedState      fileState
+}
+
+// fileState identifies a version of the JSON file and its journal.
+type fileState struct {
+	modTime        time.Time
+	size           int64
+	journalModTime time.Time
+	journalSize    int64
+}
+
+type journalEntry struct {
+	Op    string
+	Photo Photo
+}
+
+func (jfps *JsonFilePhotoStore) journalFileName() string {
+	return jfps.FileName + ".journal"
+}
+
+func (jfps *JsonFilePhotoStore) currentFileState() (fileState, error) {
+	state := fileState{}
+	info, err := os.Stat(jfps.FileName)
+	if err != nil {
+		return state, err
+	}
+	state.modTime, state.size = info.ModTime(), info.Size()
+	info, err = os.Stat(jfps.journalFileName())
+	if err == nil {
+		state.journalModTime, state.journalSize = info.ModTime(), info.Size()
+	} else if !os.IsNotExist(err) {
+		return state, err
+	}
+	return state, nil
+}
+
+// readFiles replaces the photos with the content of the JSON file and its
+// journal.
+func (jfps *JsonFilePhotoStore) readFiles() (torn bool, err error) {
+	state, err := jfps.currentFileState()
+	if err != nil {
+		return false, err
+	}
+	contentBytes, err := ioutil.ReadFile(jfps.FileName)
+	if err != nil {
+		return false, err
+	}
+	jfps.photos = nil
+	if err := json.Unmarshal(contentBytes, &jfps.photos); err != nil {
+		return false, err
+	}
+	jfps.hashIndex = nil
+	for _, photo := range jfps.photos {
+		jfps.indexHash(photo)
+	}
+	torn, err = jfps.replayJournal()
+	if err != nil {
+		return false, err
+	}
+	jfps.loadedState = state
+	return torn, nil
 }
 
 func (jfps *JsonFilePhotoStore) LoadFromFile() error {
 	jfps.mutex.Lock()
 	defer jfps.mutex.Unlock()
-	contentBytes, err := ioutil.ReadFile(jfps.FileName)
+	torn, err := jfps.readFiles()
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(contentBytes, &jfps.photos)
+	jfps.journalTorn = torn
+	jfps.loaded = true
+	return nil
+}
+
+// replayJournal applies the journal entries on top of the loaded photos.
+// Replaying is idempotent, and a last entry without its newline (crash while
+// writing it, or another process writing it right now) is ignored, in which
+// case torn is true. Any other unreadable entry is an error.
+func (jfps *JsonFilePhotoStore) replayJournal() (torn bool, err error) {
+	f, err := os.Open(jfps.journalFileName())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	jfps.journalEntries = 0
+	reader := bufio.NewReader(f)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return len(line) > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		entry := journalEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return false, errors.New("Corrupted journal " + jfps.journalFileName() + " at line " + strconv.Itoa(lineNumber) + " : " + err.Error())
+		}
+		switch entry.Op {
+		case "add":
+			jfps.add(entry.Photo)
+		case "update":
+			jfps.update(entry.Photo)
+		case "remove":
+			jfps.remove(entry.Photo)
+		}
+		jfps.journalEntries++
+	}
+}
+
+func (jfps *JsonFilePhotoStore) openJournal() error {
+	if jfps.journal != nil {
+		return nil
+	}
+	f, err := os.OpenFile(jfps.journalFileName(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	jfps.journal = f
+	return nil
+}
+
+func (jfps *JsonFilePhotoStore) closeJournal() {
+	if jfps.journal != nil {
+		jfps.journal.Close()
+		jfps.journal = nil
+	}
+	jfps.journalEntries = 0
+}
+
+// writeJournal appends an entry to the journal, opening it on the first
+// change, and compacts it when it gets too long. It is a no-op while the
+// store has not been loaded.
+func (jfps *JsonFilePhotoStore) writeJournal(op string, photo Photo) error {
+	if !jfps.loaded {
+		return nil
+	}
+	if err := jfps.openJournal(); err != nil {
+		return err
+	}
+	if jfps.journalTorn {
+		// new entries must not be appended after a partial line
+		if err := jfps.storeToFile(); err != nil {
+			return err
+		}
+	}
+	bytes, err := json.Marshal(journalEntry{Op: op, Photo: photo})
+	if err != nil {
+		return err
+	}
+	if _, err := jfps.journal.Write(append(bytes, '\n')); err != nil {
+		return err
+	}
+	if err := jfps.journal.Sync(); err != nil {
+		return err
+	}
+	jfps.journalEntries++
+
+	threshold := jfps.CompactThreshold
+	if threshold <= 0 {
+		threshold = defaultCompactThreshold
+	}
+	if jfps.journalEntries >= threshold {
+		return jfps.storeToFile()
+	}
+	return nil
+}
+
+// Reload reads the JSON file and its journal again if another process
+// changed them since they were last read, then swaps in the new photos.
+// Slices previously returned by GetAll are left untouched.
+func (jfps *JsonFilePhotoStore) Reload() error {
+	jfps.mutex.Lock()
+	loadedState := jfps.loadedState
+	jfps.mutex.Unlock()
+
+	state, err := jfps.currentFileState()
+	if err != nil || state == loadedState {
+		return err
+	}
+	reloaded := JsonFilePhotoStore{FileName: jfps.FileName}
+	if _, err := reloaded.readFiles(); err != nil {
+		return err
+	}
+
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	jfps.photos = reloaded.photos
+	jfps.hashIndex = reloaded.hashIndex
+	jfps.loadedState = reloaded.loadedState
+	return nil
 }
 
 func (jfps *JsonFilePhotoStore) StoreToFile() error {
 	jfps.mutex.Lock()
 	defer jfps.mutex.Unlock()
+	if jfps.loaded {
+		if err := jfps.openJournal(); err != nil {
+			return err
+		}
+	}
+	return jfps.storeToFile()
+}
+
+// storeToFile atomically replaces the JSON file (write to a temporary file,
+// sync, rename) then truncates the journal.
+func (jfps *JsonFilePhotoStore) storeToFile() error {
 	bytes, err := json.Marshal(jfps.photos)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(jfps.FileName, bytes, os.FileMode(0644))
+	if jfps.photos == nil {
+		bytes = []byte("[]")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(jfps.FileName), filepath.Base(jfps.FileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(os.FileMode(0644)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), jfps.FileName); err != nil {
+		return err
+	}
+
+	if jfps.journal != nil {
+		if err := jfps.journal.Truncate(0); err != nil {
+			return err
+		}
+		jfps.journalEntries = 0
+		jfps.journalTorn = false
+	}
+	return nil
 }
 
 func (jfps *JsonFilePhotoStore) RemoveStorageFile() error {
 	jfps.mutex.Lock()
 	defer jfps.mutex.Unlock()
+	jfps.closeJournal()
+	if err := os.Remove(jfps.journalFileName()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Remove(jfps.FileName)
 }
 
@@ -55,10 +316,62 @@ func (jfps *JsonFilePhotoStore) Touch() error {
 }
 
 func (jfps *JsonFilePhotoStore) GetAll() []Photo {
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
 	return jfps.photos
 }
 
+func (jfps *JsonFilePhotoStore) GetByAlbum(albumDateTime int) []Photo {
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	photos := []Photo{}
+	for _, photo := range jfps.photos {
+		if photo.AlbumDateTime == albumDateTime {
+			photos = append(photos, photo)
+		}
+	}
+	return photos
+}
+
+func (jfps *JsonFilePhotoStore) GetByHash(hash string) []Photo {
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	return append([]Photo{}, jfps.hashIndex[hash]...)
+}
+
+// indexHash adds photo to the hash index.
+func (jfps *JsonFilePhotoStore) indexHash(photo Photo) {
+	if photo.Hash == "" {
+		return
+	}
+	if jfps.hashIndex == nil {
+		jfps.hashIndex = map[string][]Photo{}
+	}
+	jfps.hashIndex[photo.Hash] = append(jfps.hashIndex[photo.Hash], photo)
+}
+
+// unindexHash removes photo from the hash index.
+func (jfps *JsonFilePhotoStore) unindexHash(photo Photo) {
+	indexed := []Photo{}
+	for _, other := range jfps.hashIndex[photo.Hash] {
+		if other.Filename != photo.Filename {
+			indexed = append(indexed, other)
+		}
+	}
+	if len(indexed) == 0 {
+		delete(jfps.hashIndex, photo.Hash)
+		return
+	}
+	jfps.hashIndex[photo.Hash] = indexed
+}
+
 func (jfps *JsonFilePhotoStore) Get(fileName string) (Photo, error) {
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	return jfps.get(fileName)
+}
+
+func (jfps *JsonFilePhotoStore) get(fileName string) (Photo, error) {
 	for _, photo := range jfps.photos {
 		if photo.Filename == fileName {
 			return photo, nil
@@ -70,23 +383,96 @@ func (jfps *JsonFilePhotoStore) Get(fileName string) (Photo, error) {
 func (jfps *JsonFilePhotoStore) Add(photo Photo) error {
 	jfps.mutex.Lock()
 	defer jfps.mutex.Unlock()
-	if _, err := jfps.Get(photo.Filename); err == nil {
+	if err := jfps.add(photo); err != nil {
+		return err
+	}
+	return jfps.writeJournal("add", photo)
+}
+
+func (jfps *JsonFilePhotoStore) add(photo Photo) error {
+	if _, err := jfps.get(photo.Filename); err == nil {
 		return errors.New("Filename already exists")
 	}
 	jfps.photos = append(jfps.photos, photo)
+	jfps.indexHash(photo)
 	return nil
 }
 
+func (jfps *JsonFilePhotoStore) Update(photo Photo) error {
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	if err := jfps.update(photo); err != nil {
+		return err
+	}
+	return jfps.writeJournal("update", photo)
+}
+
+func (jfps *JsonFilePhotoStore) update(photo Photo) error {
+	for i := range jfps.photos {
+		if jfps.photos[i].Filename == photo.Filename {
+			// build a new slice: GetAll callers may still hold the old one
+			photos := make([]Photo, len(jfps.photos))
+			copy(photos, jfps.photos)
+			jfps.unindexHash(photos[i])
+			photos[i] = photo
+			jfps.photos = photos
+			jfps.indexHash(photo)
+			return nil
+		}
+	}
+	return errors.New("No photo found for filename " + photo.Filename)
+}
+
 func (jfps *JsonFilePhotoStore) Remove(photoToRemove Photo) error {
 	jfps.mutex.Lock()
 	defer jfps.mutex.Unlock()
+	if err := jfps.remove(photoToRemove); err != nil {
+		return err
+	}
+	return jfps.writeJournal("remove", photoToRemove)
+}
+
+func (jfps *JsonFilePhotoStore) remove(photoToRemove Photo) error {
 	for i := len(jfps.photos) - 1; i >= 0; i-- {
 		photo := jfps.photos[i]
 		// Condition to decide if current element has to be deleted:
 		if photo == photoToRemove {
-			jfps.photos = append(jfps.photos[:i], jfps.photos[i+1:]...)
+			// build a new slice: GetAll callers may still hold the old one
+			photos := make([]Photo, 0, len(jfps.photos)-1)
+			photos = append(photos, jfps.photos[:i]...)
+			jfps.photos = append(photos, jfps.photos[i+1:]...)
+			jfps.unindexHash(photo)
 			return nil
 		}
 	}
 	return errors.New("Photo not in list")
 }
+
+func (jfps *JsonFilePhotoStore) Init() error {
+	if err := jfps.Touch(); err != nil {
+		return err
+	}
+	return jfps.LoadFromFile()
+}
+
+func (jfps *JsonFilePhotoStore) Erase() error {
+	if err := jfps.RemoveStorageFile(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	jfps.mutex.Lock()
+	jfps.photos = nil
+	jfps.hashIndex = nil
+	jfps.mutex.Unlock()
+	if err := jfps.Touch(); err != nil {
+		return err
+	}
+	jfps.mutex.Lock()
+	defer jfps.mutex.Unlock()
+	jfps.journalTorn = false
+	jfps.loaded = true
+	return jfps.openJournal()
+}
+
+func (jfps *JsonFilePhotoStore) Flush() error {
+	return jfps.StoreToFile()
+}
@@ -110,3 +110,214 @@ func TestStoreToFileAndRestore(t *testing.T) {
 		t.Error("Storage file should be removed")
 	}
 }
+
+func TestErase(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	err := jsonFilePhotoStore.Init()
+	if err != nil {
+		t.Error(err)
+	}
+	jsonFilePhotoStore.Add(photoFixture("filename1"))
+	jsonFilePhotoStore.Flush()
+
+	err = jsonFilePhotoStore.Erase()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(jsonFilePhotoStore.GetAll()) != 0 {
+		t.Error("photos should be erased from memory")
+	}
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.LoadFromFile()
+	if len(jsonFilePhotoStore.GetAll()) != 0 {
+		t.Error("photos should be erased from file")
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
+
+func TestJournalReplay(t *testing.T) {
+	photo1 := photoFixture("filename1")
+	photo2 := photoFixture("filename2")
+	photo3 := photoFixture("filename3")
+
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.RemoveStorageFile()
+	err := jsonFilePhotoStore.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFilePhotoStore.Add(photo1)
+	jsonFilePhotoStore.Add(photo2)
+	jsonFilePhotoStore.Remove(photo1)
+	jsonFilePhotoStore.Add(photo3)
+
+	// simulate a crash in the middle of a journal write
+	f, _ := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"Op":"add","Photo":{"Filen`)
+	f.Close()
+
+	//load without StoreToFile
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	err = jsonFilePhotoStore.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	allPhotos := jsonFilePhotoStore.GetAll()
+	if len(allPhotos) != 2 || allPhotos[0] != photo2 || allPhotos[1] != photo3 {
+		t.Error("journal not replayed", allPhotos)
+	}
+
+	//the torn entry should be compacted away so that new entries are readable
+	jsonFilePhotoStore.Add(photo1)
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.Init()
+	if len(jsonFilePhotoStore.GetAll()) != 3 {
+		t.Error("entry written after a torn entry lost")
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
+
+func TestJournalTornByAnotherProcess(t *testing.T) {
+	back := JsonFilePhotoStore{FileName: filename}
+	back.RemoveStorageFile()
+	back.Init()
+	back.Add(photoFixture("filename1"))
+
+	// the back is in the middle of a journal write when the front loads
+	f, _ := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"Op":"add","Photo":{"Filen`)
+	f.Close()
+	front := JsonFilePhotoStore{FileName: filename}
+	if err := front.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(front.GetAll()) != 1 {
+		t.Error("complete entries should be replayed", front.GetAll())
+	}
+	info, err := os.Stat(filename + ".journal")
+	if err != nil || info.Size() == 0 {
+		t.Error("a reading process should not compact the journal")
+	}
+	back.RemoveStorageFile()
+}
+
+func TestJournalCorrupted(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.RemoveStorageFile()
+	jsonFilePhotoStore.Init()
+	jsonFilePhotoStore.Add(photoFixture("filename1"))
+
+	f, _ := os.OpenFile(filename+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("garbage\n")
+	f.Close()
+	jsonFilePhotoStore.Add(photoFixture("filename2"))
+
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	if err := jsonFilePhotoStore.Init(); err == nil {
+		t.Error("a corrupted entry followed by other entries should fail loading")
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
+
+func TestJournalCompaction(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename, CompactThreshold: 2}
+	jsonFilePhotoStore.RemoveStorageFile()
+	jsonFilePhotoStore.Init()
+	jsonFilePhotoStore.Add(photoFixture("filename1"))
+	jsonFilePhotoStore.Add(photoFixture("filename2"))
+
+	info, err := os.Stat(filename + ".journal")
+	if err != nil || info.Size() != 0 {
+		t.Error("journal should be compacted")
+	}
+	stored := JsonFilePhotoStore{FileName: filename}
+	stored.LoadFromFile()
+	if len(stored.GetAll()) != 2 {
+		t.Error("compacted photos should be in the JSON file")
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
+
+func TestReload(t *testing.T) {
+	front := JsonFilePhotoStore{FileName: filename}
+	front.RemoveStorageFile()
+	front.Init()
+	allPhotos := front.GetAll()
+
+	back := JsonFilePhotoStore{FileName: filename}
+	back.Init()
+	back.Add(photoFixture("filename1"))
+
+	err := front.Reload()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(front.GetAll()) != 1 {
+		t.Error("journaled photo should be reloaded")
+	}
+	if len(allPhotos) != 0 {
+		t.Error("previously returned photos should be untouched")
+	}
+
+	back.Add(photoFixture("filename2"))
+	back.StoreToFile()
+	front.Reload()
+	if len(front.GetAll()) != 2 {
+		t.Error("stored photos should be reloaded")
+	}
+	back.RemoveStorageFile()
+}
+
+func TestUpdate(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.RemoveStorageFile()
+	jsonFilePhotoStore.Init()
+	jsonFilePhotoStore.Add(photoFixture("filename1"))
+	allPhotos := jsonFilePhotoStore.GetAll()
+
+	updated := photoFixture("filename1")
+	updated.Model = "model"
+	err := jsonFilePhotoStore.Update(updated)
+	if err != nil {
+		t.Error(err)
+	}
+	if photo, _ := jsonFilePhotoStore.Get("filename1"); photo != updated {
+		t.Error("photo should be updated")
+	}
+	if allPhotos[0].Model != "" {
+		t.Error("previously returned photos should be untouched")
+	}
+	if err = jsonFilePhotoStore.Update(photoFixture("filename2")); err == nil {
+		t.Error("unknown photo should not be updated")
+	}
+
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.Init()
+	if photo, _ := jsonFilePhotoStore.Get("filename1"); photo != updated {
+		t.Error("update should be replayed from the journal")
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
+
+func TestGetByHash(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.RemoveStorageFile()
+	jsonFilePhotoStore.Init()
+	jsonFilePhotoStore.Add(duplicateFixture("a.jpg", "h1", ""))
+	jsonFilePhotoStore.Add(duplicateFixture("b.jpg", "h1", "a.jpg"))
+	jsonFilePhotoStore.Update(duplicateFixture("b.jpg", "h2", ""))
+	if photos := jsonFilePhotoStore.GetByHash("h1"); len(photos) != 1 || photos[0].Filename != "a.jpg" {
+		t.Error("only a.jpg has the hash h1", photos)
+	}
+
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.Init()
+	if photos := jsonFilePhotoStore.GetByHash("h2"); len(photos) != 1 || photos[0].Filename != "b.jpg" {
+		t.Error("the hash index should be built on load", photos)
+	}
+	jsonFilePhotoStore.Remove(duplicateFixture("b.jpg", "h2", ""))
+	if photos := jsonFilePhotoStore.GetByHash("h2"); len(photos) != 0 {
+		t.Error("removed photos should leave the hash index", photos)
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
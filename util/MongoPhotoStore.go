@@ -1,11 +1,16 @@
 package util
 
 import (
+	"errors"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"log"
+	"sync"
 )
 
 const filenameProperty string = "filename"
+const albumDateTimeProperty string = "albumdatetime"
+const hashProperty string = "hash"
 
 type MongoPhotoStore struct {
 	Url            string
@@ -13,6 +18,14 @@ type MongoPhotoStore struct {
 	CollectionName string
 	collection     *mgo.Collection
 	session        *mgo.Session
+	mutex          sync.Mutex
+}
+
+func (mpr *MongoPhotoStore) Init() error {
+	if err := mpr.Ping(); err != nil {
+		return err
+	}
+	return mpr.getConnection().EnsureIndexKey(hashProperty)
 }
 
 func (mpr *MongoPhotoStore) Ping() error {
@@ -25,6 +38,8 @@ func (mpr *MongoPhotoStore) Ping() error {
 }
 
 func (mpr *MongoPhotoStore) getConnection() *mgo.Collection {
+	mpr.mutex.Lock()
+	defer mpr.mutex.Unlock()
 	if mpr.collection == nil {
 		session, mgoerr := mgo.Dial(mpr.Url)
 		if mgoerr != nil {
@@ -44,23 +59,67 @@ func (mpr *MongoPhotoStore) closeConnection() {
 	}
 }
 
-func (mpr *MongoPhotoStore) StorePhoto(photo Photo) error {
-	result := Photo{}
+func (mpr *MongoPhotoStore) Add(photo Photo) error {
 	con := mpr.getConnection()
-	err := con.Find(bson.M{filenameProperty: photo.Filename}).One(&result)
+	count, err := con.Find(bson.M{filenameProperty: photo.Filename}).Count()
 	if err != nil {
-		return con.Insert(photo)
+		return err
 	}
+	if count > 0 {
+		return errors.New("Filename already exists")
+	}
+	return con.Insert(photo)
+}
+
+// Reload is a no-op: reads always go to the database.
+func (mpr *MongoPhotoStore) Reload() error {
 	return nil
 }
 
-func (mpr *MongoPhotoStore) LookupPhoto(fileName string) (Photo, error) {
+func (mpr *MongoPhotoStore) Get(fileName string) (Photo, error) {
 	result := Photo{}
 	con := mpr.getConnection()
 	err := con.Find(bson.M{filenameProperty: fileName}).One(&result)
 	return result, err
 }
 
+func (mpr *MongoPhotoStore) GetAll() []Photo {
+	photos := []Photo{}
+	for photo := range mpr.PhotoStream() {
+		photos = append(photos, photo)
+	}
+	return photos
+}
+
+func (mpr *MongoPhotoStore) GetByAlbum(albumDateTime int) []Photo {
+	photos := []Photo{}
+	err := mpr.getConnection().Find(bson.M{albumDateTimeProperty: albumDateTime}).All(&photos)
+	if err != nil {
+		log.Println(err)
+	}
+	return photos
+}
+
+func (mpr *MongoPhotoStore) GetByHash(hash string) []Photo {
+	photos := []Photo{}
+	if hash == "" {
+		return photos
+	}
+	err := mpr.getConnection().Find(bson.M{hashProperty: hash}).All(&photos)
+	if err != nil {
+		log.Println(err)
+	}
+	return photos
+}
+
+func (mpr *MongoPhotoStore) Update(photo Photo) error {
+	return mpr.getConnection().Update(bson.M{filenameProperty: photo.Filename}, photo)
+}
+
+func (mpr *MongoPhotoStore) Remove(photo Photo) error {
+	return mpr.getConnection().Remove(bson.M{filenameProperty: photo.Filename})
+}
+
 func (mpr *MongoPhotoStore) PhotoStream() chan Photo {
 
 	photoStream := make(chan Photo, 1)
@@ -80,8 +139,14 @@ func (mpr *MongoPhotoStore) PhotoStream() chan Photo {
 	return photoStream
 }
 
-func (mpr *MongoPhotoStore) EraseDB() {
-	con := mpr.getConnection()
-	con.DropCollection()
-	mpr.collection = nil
+func (mpr *MongoPhotoStore) Erase() error {
+	err := mpr.getConnection().DropCollection()
+	if err != nil && err.Error() != "ns not found" {
+		return err
+	}
+	return nil
+}
+
+func (mpr *MongoPhotoStore) Flush() error {
+	return nil
 }
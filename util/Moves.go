@@ -0,0 +1,62 @@
+package util
+
+// FindMoved returns the photo having the given hash whose source file is
+// gone, exists telling whether a name is in the source folder: the source
+// file was moved or renamed. Originals are preferred to duplicates, for
+// folders of copies moved at once.
+func FindMoved(photos []Photo, hash string, exists func(name string) bool) (Photo, bool) {
+	if hash == "" {
+		return Photo{}, false
+	}
+	var moved Photo
+	found := false
+	for _, photo := range photos {
+		if photo.Hash != hash || exists(photo.Filename) {
+			continue
+		}
+		if photo.DuplicateOf == "" {
+			return photo, true
+		}
+		if !found {
+			moved, found = photo, true
+		}
+	}
+	return moved, found
+}
+
+// MovedFrom makes p, read from a source file moved or renamed, take over
+// stored, the photo stored for the file at its former place: p keeps its
+// objects and the fields the source file doesn't tell (see CarryOver). It
+// also keeps its RAW file, and its group unless p was given one, as the
+// files it is linked to may not be moved yet.
+func (p *Photo) MovedFrom(stored Photo) {
+	p.CarryOver(stored)
+	p.ObjectKey = stored.Key()
+	if p.RawFilename == "" {
+		p.RawFilename = stored.RawFilename
+	}
+	if p.GroupID == "" {
+		p.GroupID = stored.GroupID
+	}
+}
+
+// MovePhoto replaces the stored photo by moved (see MovedFrom), the
+// duplicates of photo becoming the duplicates of moved.
+func MovePhoto(store PhotoStore, photo Photo, moved Photo) error {
+	if err := store.Remove(photo); err != nil {
+		return err
+	}
+	if err := store.Add(moved); err != nil {
+		return err
+	}
+	for _, duplicate := range store.GetAll() {
+		if duplicate.DuplicateOf != photo.Filename {
+			continue
+		}
+		duplicate.DuplicateOf = moved.Filename
+		if err := store.Update(duplicate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestFindMoved(t *testing.T) {
+	photos := []Photo{
+		duplicateFixture("kept.jpg", "h1", ""),
+		duplicateFixture("old/copy.jpg", "h2", "new/a.jpg"),
+		duplicateFixture("old/a.jpg", "h2", ""),
+	}
+	exists := func(name string) bool { return name == "kept.jpg" }
+	if _, found := FindMoved(photos, "h1", exists); found {
+		t.Error("kept.jpg is still there")
+	}
+	if moved, found := FindMoved(photos, "h2", exists); !found || moved.Filename != "old/a.jpg" {
+		t.Error("old/a.jpg should be moved, before its duplicate", moved)
+	}
+	if _, found := FindMoved(photos, "", exists); found {
+		t.Error("photos without hash can't be found")
+	}
+}
+
+func TestMovePhoto(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	photo := duplicateFixture("old/a.jpg", "h1", "")
+	photo.RenditionsHash, photo.PerceptualHash = "r1", "0123456789abcdef"
+	photo.RawFilename, photo.GroupID = "old/a.CR2", "live-ID"
+	jsonFilePhotoStore.Add(photo)
+	jsonFilePhotoStore.Add(duplicateFixture("b.jpg", "h1", "old/a.jpg"))
+
+	moved := duplicateFixture("new/a.jpg", "h1", "")
+	moved.ObjectKey = "h1.jpg"
+	moved.MovedFrom(photo)
+	if moved.ObjectKey != "old/a.jpg" || moved.RenditionsHash != "r1" || moved.PerceptualHash != "0123456789abcdef" {
+		t.Error("moved should keep the objects and renditions of old/a.jpg", moved)
+	}
+	if moved.RawFilename != "old/a.CR2" || moved.GroupID != "live-ID" {
+		t.Error("moved should keep the RAW file and the group of old/a.jpg", moved)
+	}
+	if err := MovePhoto(&jsonFilePhotoStore, photo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jsonFilePhotoStore.Get("old/a.jpg"); err == nil {
+		t.Error("old/a.jpg should be gone")
+	}
+	if stored, err := jsonFilePhotoStore.Get("new/a.jpg"); err != nil || stored != moved {
+		t.Error("new/a.jpg should be stored", stored, err)
+	}
+	if b, _ := jsonFilePhotoStore.Get("b.jpg"); b.DuplicateOf != "new/a.jpg" {
+		t.Error("b.jpg should be a duplicate of new/a.jpg", b)
+	}
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// 3x2 image, marked top left pixel
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.White)
+
+	// where the marked pixel should end up, and the resulting width
+	expected := map[int][3]int{
+		1: {0, 0, 3},
+		2: {2, 0, 3},
+		3: {2, 1, 3},
+		4: {0, 1, 3},
+		5: {0, 0, 2},
+		6: {1, 0, 2},
+		7: {1, 2, 2},
+		8: {0, 2, 2},
+	}
+	for orientation, position := range expected {
+		oriented := ApplyOrientation(img, orientation)
+		if oriented.Bounds().Dx() != position[2] {
+			t.Error(orientation, "wrong size", oriented.Bounds())
+		}
+		r, _, _, _ := oriented.At(position[0], position[1]).RGBA()
+		if r != 0xffff {
+			t.Error(orientation, "marked pixel should be at", position[0], position[1])
+		}
+	}
+}
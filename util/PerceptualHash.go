@@ -0,0 +1,153 @@
+package util
+
+import (
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// PerceptualHash returns the difference hash (dHash) of img, as 16 hex
+// digits: img is shrunk to 9x8 gray pixels, and each bit tells whether a
+// pixel is brighter than its right neighbour. Resized and recompressed
+// copies of a photo have hashes a few bits apart.
+func PerceptualHash(img image.Image) string {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		left := gray(small.At(bounds.Min.X, bounds.Min.Y+y))
+		for x := 1; x < 9; x++ {
+			right := gray(small.At(bounds.Min.X+x, bounds.Min.Y+y))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+			left = right
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+func gray(c color.Color) uint8 {
+	return color.GrayModel.Convert(c).(color.Gray).Y
+}
+
+// parsePerceptualHash parses a hash returned by PerceptualHash.
+func parsePerceptualHash(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, err == nil
+}
+
+// HashDistance returns the number of bits two perceptual hashes differ by,
+// or -1 if one of them is not a valid hash.
+func HashDistance(a string, b string) int {
+	hashA, validA := parsePerceptualHash(a)
+	hashB, validB := parsePerceptualHash(b)
+	if !validA || !validB {
+		return -1
+	}
+	return bits.OnesCount64(hashA ^ hashB)
+}
+
+// SimilarPhoto is a photo looking like another one, Distance bits apart.
+type SimilarPhoto struct {
+	Photo
+	Distance int
+}
+
+// similarCandidates returns the photos having a perceptual hash, which are
+// neither duplicates nor hidden, with their parsed hashes.
+func similarCandidates(photos []Photo) ([]Photo, []uint64) {
+	candidates := []Photo{}
+	hashes := []uint64{}
+	for _, photo := range photos {
+		if hash, valid := parsePerceptualHash(photo.PerceptualHash); valid && photo.DuplicateOf == "" && !photo.Hidden {
+			candidates = append(candidates, photo)
+			hashes = append(hashes, hash)
+		}
+	}
+	return candidates, hashes
+}
+
+// SimilarPhotos returns the photos whose perceptual hash is at most
+// maxDistance bits away from the one of photo, closest first. The photo
+// itself, its duplicates and the photos of its group are left out.
+func SimilarPhotos(photos []Photo, photo Photo, maxDistance int) []SimilarPhoto {
+	similar := []SimilarPhoto{}
+	hash, valid := parsePerceptualHash(photo.PerceptualHash)
+	if !valid {
+		return similar
+	}
+	candidates, hashes := similarCandidates(photos)
+	for i, candidate := range candidates {
+		if candidate.Filename == photo.Filename || sameGroup(candidate, photo) {
+			continue
+		}
+		if distance := bits.OnesCount64(hash ^ hashes[i]); distance <= maxDistance {
+			similar = append(similar, SimilarPhoto{Photo: candidate, Distance: distance})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].Filename < similar[j].Filename
+	})
+	return similar
+}
+
+// SimilarClusters gathers the photos linked by perceptual hashes at most
+// maxDistance bits apart, transitively. Only the clusters of more than one
+// photo are returned, by filename, sorted by their first filename. Photos
+// of the same group (bursts, Live Photos) don't link each other.
+func SimilarClusters(photos []Photo, maxDistance int) [][]Photo {
+	candidates, hashes := similarCandidates(photos)
+	parents := make([]int, len(candidates))
+	for i := range parents {
+		parents[i] = i
+	}
+	root := func(i int) int {
+		for parents[i] != i {
+			parents[i] = parents[parents[i]]
+			i = parents[i]
+		}
+		return i
+	}
+	for i := range candidates {
+		for j := i + 1; j < len(candidates); j++ {
+			if bits.OnesCount64(hashes[i]^hashes[j]) <= maxDistance && !sameGroup(candidates[i], candidates[j]) {
+				parents[root(i)] = root(j)
+			}
+		}
+	}
+
+	byRoot := map[int][]Photo{}
+	for i, candidate := range candidates {
+		byRoot[root(i)] = append(byRoot[root(i)], candidate)
+	}
+	clusters := [][]Photo{}
+	for _, cluster := range byRoot {
+		if len(cluster) < 2 {
+			continue
+		}
+		sort.Slice(cluster, func(i, j int) bool {
+			return cluster[i].Filename < cluster[j].Filename
+		})
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0].Filename < clusters[j][0].Filename
+	})
+	return clusters
+}
+
+func sameGroup(a Photo, b Photo) bool {
+	return a.GroupID != "" && a.GroupID == b.GroupID
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bytes"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// patternImage draws diagonal bands, at a different angle for each seed.
+func patternImage(w int, h int, seed int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*(seed+1)*7/w + y*(3-seed)*5/h) * 40)
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := patternImage(400, 300, 0)
+	hash := PerceptualHash(original)
+	if len(hash) != 16 {
+		t.Fatal("hash should be 16 hex digits", hash)
+	}
+
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, resize.Resize(120, 90, original, resize.Bilinear), &jpeg.Options{Quality: 30})
+	resized, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance := HashDistance(hash, PerceptualHash(resized)); distance < 0 || distance > 4 {
+		t.Error("resized and recompressed copy too far", distance)
+	}
+	if distance := HashDistance(hash, PerceptualHash(patternImage(400, 300, 2))); distance <= 10 {
+		t.Error("other image too close", distance)
+	}
+	if HashDistance(hash, "not a hash") != -1 {
+		t.Error("invalid hashes have no distance")
+	}
+}
+
+func TestSimilarPhotos(t *testing.T) {
+	photo := Photo{Filename: "a.jpg", PerceptualHash: "ff00ff00ff00ff00", GroupID: "burst-1"}
+	near := Photo{Filename: "b.jpg", PerceptualHash: "ff00ff00ff00ff01"}
+	nearer := Photo{Filename: "c.jpg", PerceptualHash: "ff00ff00ff00ff00"}
+	far := Photo{Filename: "d.jpg", PerceptualHash: "00ff00ff00ff00ff"}
+	sameBurst := Photo{Filename: "e.jpg", PerceptualHash: "ff00ff00ff00ff00", GroupID: "burst-1"}
+	duplicate := Photo{Filename: "f.jpg", PerceptualHash: "ff00ff00ff00ff00", DuplicateOf: "c.jpg"}
+	unhashed := Photo{Filename: "g.jpg"}
+
+	similar := SimilarPhotos([]Photo{photo, near, nearer, far, sameBurst, duplicate, unhashed}, photo, 4)
+	want := []SimilarPhoto{{Photo: nearer, Distance: 0}, {Photo: near, Distance: 1}}
+	if !reflect.DeepEqual(similar, want) {
+		t.Errorf("SimilarPhotos = %v, want %v", similar, want)
+	}
+	if similar := SimilarPhotos([]Photo{photo, near}, unhashed, 4); len(similar) != 0 {
+		t.Error("a photo without hash has no similar photos", similar)
+	}
+}
+
+func TestSimilarClusters(t *testing.T) {
+	a := Photo{Filename: "a.jpg", PerceptualHash: "0000000000000000"}
+	b := Photo{Filename: "b.jpg", PerceptualHash: "0000000000000007"}
+	c := Photo{Filename: "c.jpg", PerceptualHash: "000000000000003f"}
+	d := Photo{Filename: "d.jpg", PerceptualHash: "ffffffffffffffff"}
+	e := Photo{Filename: "e.jpg", PerceptualHash: "fffffffffffffff8", GroupID: "live-e"}
+	f := Photo{Filename: "f.jpg", PerceptualHash: "fffffffffffffff8", GroupID: "live-e"}
+
+	// a-b and b-c are 3 bits apart, a-c 6 bits
+	clusters := SimilarClusters([]Photo{c, d, e, f, a, b}, 3)
+	want := [][]Photo{{a, b, c}, {d, e, f}}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("SimilarClusters = %v, want %v", clusters, want)
+	}
+	clusters = SimilarClusters([]Photo{e, f}, 3)
+	if len(clusters) != 0 {
+		t.Error("photos of the same group don't link each other", clusters)
+	}
+}
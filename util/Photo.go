@@ -1,11 +1,116 @@
 package util
 
+import (
+	"time"
+)
+
 type Photo struct {
 	DateTime      int
 	AlbumDateTime int
-	Filename      string
-	ThumbUrl      string `json:",omitempty"`
-	MediumUrl     string `json:",omitempty"`
+	// TimeZoneOffset is the offset in seconds east of UTC where the photo
+	// was taken, TimeZoneSource tells how it was found.
+	TimeZoneOffset int
+	TimeZoneSource string `json:",omitempty"`
+	// DateSource tells where DateTime comes from: exif, xmp, filename,
+	// folder or mtime. Photos stored before it was recorded have none.
+	DateSource   string `json:",omitempty"`
+	Filename     string
+	Latitude     float64 `json:",omitempty"`
+	Longitude    float64 `json:",omitempty"`
+	Make         string  `json:",omitempty"`
+	Model        string  `json:",omitempty"`
+	Lens         string  `json:",omitempty"`
+	FocalLength  float64 `json:",omitempty"` // mm
+	Aperture     float64 `json:",omitempty"` // f-number
+	ExposureTime string  `json:",omitempty"` // seconds, as 1/250
+	ISO          int     `json:",omitempty"`
+	Width        int     `json:",omitempty"`
+	Height       int     `json:",omitempty"`
+	Orientation  int     `json:",omitempty"` // EXIF orientation, 1 to 8
+	FileSize     int64   `json:",omitempty"`
+	// MediaType is MediaVideo for videos, empty for photos.
+	MediaType string  `json:",omitempty"`
+	Duration  float64 `json:",omitempty"` // seconds, videos only
+	// Format is the format of the source file (see DetectFormat), empty
+	// meaning jpeg for photos stored by older versions.
+	Format string `json:",omitempty"`
+	// RawFilename is the RAW file shot along a JPEG, uploaded next to it as
+	// a second original.
+	RawFilename string `json:",omitempty"`
+	// RenditionsHash is the RenditionsHash of the profiles the renditions
+	// were last checked or rendered with.
+	RenditionsHash string `json:",omitempty"`
+	// Hash is the hex encoded SHA-256 of the source file, and ObjectKey the
+	// key its objects are stored under in S3 (see ContentKey). Photos stored
+	// before content addressing have neither, see Key.
+	Hash      string `json:",omitempty"`
+	ObjectKey string `json:",omitempty"`
+	// DuplicateOf is the Filename of the photo having the same content,
+	// whose objects this one shares.
+	DuplicateOf string `json:",omitempty"`
+	// Hidden is set on the photos whose source file was removed, with the
+	// DeleteHide policy.
+	Hidden bool `json:",omitempty"`
+	// PerceptualHash is the PerceptualHash of the image, or of the poster of
+	// a video, computed when rendering.
+	PerceptualHash string `json:",omitempty"`
+	// GroupID links the image and the video of a Live Photo, or the frames
+	// of a burst (see GroupID and GroupPhotos).
+	GroupID   string `json:",omitempty"`
+	ThumbUrl  string `json:",omitempty"`
+	MediumUrl string `json:",omitempty"`
+}
+
+// SetDateTime sets when the photo was taken. t must be in the time zone the
+// photo was taken in: the album is the local date.
+func (p *Photo) SetDateTime(t time.Time, timeZoneSource string, dateSource string) {
+	_, offset := t.Zone()
+	p.DateTime = int(t.Unix())
+	p.AlbumDateTime = int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix())
+	p.TimeZoneOffset = offset
+	p.TimeZoneSource = timeZoneSource
+	p.DateSource = dateSource
+}
+
+// LocalTime returns when the photo was taken, in the time zone it was
+// taken in.
+func (p Photo) LocalTime() time.Time {
+	if p.TimeZoneSource == "" {
+		// stored before time zones were tracked, DateTime was parsed in the
+		// local time zone
+		return time.Unix(int64(p.DateTime), 0)
+	}
+	return time.Unix(int64(p.DateTime), 0).In(time.FixedZone("", p.TimeZoneOffset))
+}
+
+// Key returns the key the objects of the photo are stored under in S3: its
+// ObjectKey, or its Filename for photos uploaded before content addressing.
+func (p Photo) Key() string {
+	if p.ObjectKey != "" {
+		return p.ObjectKey
+	}
+	return p.Filename
+}
+
+// CarryOver copies from stored, the photo stored for the same source file,
+// the fields which are not read from the source file: where its objects
+// are, what it duplicates and what it was rendered with.
+func (p *Photo) CarryOver(stored Photo) {
+	p.ObjectKey = stored.ObjectKey
+	p.DuplicateOf = stored.DuplicateOf
+	p.RenditionsHash = stored.RenditionsHash
+	p.PerceptualHash = stored.PerceptualHash
+}
+
+// HasRenditions reports whether the renditions of the photo were rendered,
+// which videos only have once their poster could be extracted.
+func (p Photo) HasRenditions() bool {
+	return p.MediaType != MediaVideo || p.RenditionsHash != ""
+}
+
+// HasLocation reports whether the photo is geotagged.
+func (p Photo) HasLocation() bool {
+	return p.Latitude != 0 || p.Longitude != 0
 }
 
 type ByDateTime []Photo
@@ -0,0 +1,25 @@
+package util
+
+// PhotoStore is the storage backend used to keep track of known photos.
+// Photos are identified by their Filename.
+type PhotoStore interface {
+	// Init makes the store ready for use (create, load or connect).
+	Init() error
+	// Erase drops every stored photo.
+	Erase() error
+	// Flush persists pending changes, if the backend needs it.
+	Flush() error
+	// Reload picks up changes made by another process, if the backend
+	// does not see them already.
+	Reload() error
+	Get(fileName string) (Photo, error)
+	GetAll() []Photo
+	// GetByAlbum returns the photos whose AlbumDateTime is albumDateTime.
+	GetByAlbum(albumDateTime int) []Photo
+	// GetByHash returns the photos whose Hash is hash, from an index.
+	GetByHash(hash string) []Photo
+	Add(photo Photo) error
+	// Update replaces the stored photo having the same Filename.
+	Update(photo Photo) error
+	Remove(photo Photo) error
+}
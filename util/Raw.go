@@ -0,0 +1,187 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/rwcarlsen/goexif/tiff"
+	"image/jpeg"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FormatRaw is the format of the RAW camera files, which are TIFF based.
+const FormatRaw string = "raw"
+
+var rawMimeTypes = map[string]string{
+	".cr2": "image/x-canon-cr2",
+	".nef": "image/x-nikon-nef",
+	".arw": "image/x-sony-arw",
+	".dng": "image/x-adobe-dng",
+}
+
+var jpegExtensions = []string{".jpg", ".jpeg"}
+
+// maxPreviewSize bounds the JPEG previews read from RAW files, to skip
+// corrupted lengths.
+const maxPreviewSize = 64 << 20
+
+// IsRawName tells whether name has the extension of a supported RAW file.
+func IsRawName(name string) bool {
+	_, found := rawMimeTypes[strings.ToLower(path.Ext(name))]
+	return found
+}
+
+// IsRaw tells whether a TIFF file is a RAW camera file, from its first
+// bytes (CR2 marker) or its name.
+func IsRaw(name string, head []byte) bool {
+	if DetectFormat(head) != FormatTiff {
+		return false
+	}
+	if len(head) >= 11 && string(head[8:11]) == "CR\x02" {
+		return true
+	}
+	return IsRawName(name)
+}
+
+// JpegSiblings returns the names a JPEG shot along the RAW file name could
+// have, in the same folder.
+func JpegSiblings(name string) []string {
+	return siblings(name, jpegExtensions)
+}
+
+// RawSiblings returns the names a RAW file shot along the JPEG name could
+// have, in the same folder.
+func RawSiblings(name string) []string {
+	extensions := []string{}
+	for extension := range rawMimeTypes {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return siblings(name, extensions)
+}
+
+// siblings returns name with each extension, in lower and upper case.
+func siblings(name string, extensions []string) []string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	names := []string{}
+	for _, extension := range extensions {
+		for _, candidate := range []string{base + extension, base + strings.ToUpper(extension)} {
+			if candidate != name {
+				names = append(names, candidate)
+			}
+		}
+	}
+	return names
+}
+
+// RawPreview extracts the largest JPEG preview embedded in a RAW file. It
+// walks the IFD chain and the SubIFDs, looking for JPEG streams referenced by
+// JPEGInterchangeFormat or by the strip of a JPEG compressed image. Lossless
+// JPEG streams holding the sensor data are skipped.
+func RawPreview(r io.ReaderAt) ([]byte, error) {
+	sr := io.NewSectionReader(r, 0, 1<<62)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(sr, header); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF based RAW file")
+	}
+
+	var best []byte
+	bestArea := 0
+	visited := map[int64]bool{}
+	queue := []int64{int64(order.Uint32(header[4:]))}
+	for len(queue) > 0 && len(visited) < 32 {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+		if _, err := sr.Seek(offset, io.SeekStart); err != nil {
+			continue
+		}
+		dir, next, err := tiff.DecodeDir(sr, order)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, int64(uint32(next)))
+		if subIFDs := dirTag(dir, 0x014A); subIFDs != nil {
+			for i := 0; i < int(subIFDs.Count); i++ {
+				if subIFD, err := subIFDs.Int64(i); err == nil {
+					queue = append(queue, subIFD)
+				}
+			}
+		}
+
+		for _, candidate := range jpegCandidates(dir) {
+			start, length := candidate[0], candidate[1]
+			if length <= 0 || length > maxPreviewSize {
+				continue
+			}
+			head := make([]byte, 64*1024)
+			n, _ := r.ReadAt(head, start)
+			config, err := jpeg.DecodeConfig(bytes.NewReader(head[:n]))
+			if err != nil || config.Width*config.Height <= bestArea {
+				continue
+			}
+			preview := make([]byte, length)
+			if _, err := r.ReadAt(preview, start); err != nil {
+				continue
+			}
+			best, bestArea = preview, config.Width*config.Height
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no JPEG preview found")
+	}
+	return best, nil
+}
+
+// jpegCandidates returns the offsets and lengths of the JPEG streams an IFD
+// references.
+func jpegCandidates(dir *tiff.Dir) [][2]int64 {
+	candidates := [][2]int64{}
+	if start, length := dirInt(dir, 0x0201), dirInt(dir, 0x0202); start > 0 {
+		candidates = append(candidates, [2]int64{start, length})
+	}
+	// old style (6) or new style (7) JPEG compression, in a single strip
+	if compression := dirInt(dir, 0x0103); compression == 6 || compression == 7 {
+		offsets, counts := dirTag(dir, 0x0111), dirTag(dir, 0x0117)
+		if offsets != nil && counts != nil && offsets.Count == 1 && counts.Count == 1 {
+			candidates = append(candidates, [2]int64{dirInt(dir, 0x0111), dirInt(dir, 0x0117)})
+		}
+	}
+	return candidates
+}
+
+func dirTag(dir *tiff.Dir, id uint16) *tiff.Tag {
+	for _, tag := range dir.Tags {
+		if tag.Id == id {
+			return tag
+		}
+	}
+	return nil
+}
+
+func dirInt(dir *tiff.Dir, id uint16) int64 {
+	tag := dirTag(dir, id)
+	if tag == nil {
+		return 0
+	}
+	value, err := tag.Int64(0)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+type ifdEntry struct {
+	id    uint16
+	typ   uint16
+	value uint32
+}
+
+// writeIfd writes an IFD of LONG or SHORT entries, each with one value.
+func writeIfd(buf *bytes.Buffer, entries []ifdEntry, next uint32) {
+	binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(buf, binary.LittleEndian, entry.id)
+		binary.Write(buf, binary.LittleEndian, entry.typ)
+		binary.Write(buf, binary.LittleEndian, uint32(1))
+		if entry.typ == 3 {
+			binary.Write(buf, binary.LittleEndian, uint16(entry.value))
+			binary.Write(buf, binary.LittleEndian, uint16(0))
+		} else {
+			binary.Write(buf, binary.LittleEndian, entry.value)
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, next)
+}
+
+// fakeRaw builds a TIFF with a small preview in IFD0, a garbage stream in
+// IFD1 and a large JPEG compressed strip in a SubIFD of IFD0.
+func fakeRaw(small []byte, large []byte) []byte {
+	const ifdSize = 2 + 3*12 + 4
+	ifd0, ifd1, subIfd := uint32(8), uint32(8+ifdSize), uint32(8+2*ifdSize)
+	garbage := []byte("\xFF\xD8\xFF not really a JPEG")
+	smallOffset := uint32(8 + 3*ifdSize)
+	garbageOffset := smallOffset + uint32(len(small))
+	largeOffset := garbageOffset + uint32(len(garbage))
+
+	buf := bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, ifd0)
+	writeIfd(&buf, []ifdEntry{{0x014A, 4, subIfd}, {0x0201, 4, smallOffset}, {0x0202, 4, uint32(len(small))}}, ifd1)
+	writeIfd(&buf, []ifdEntry{{0x0201, 4, garbageOffset}, {0x0202, 4, uint32(len(garbage))}, {0x0100, 4, 1}}, 0)
+	writeIfd(&buf, []ifdEntry{{0x0103, 3, 6}, {0x0111, 4, largeOffset}, {0x0117, 4, uint32(len(large))}}, 0)
+	buf.Write(small)
+	buf.Write(garbage)
+	buf.Write(large)
+	return buf.Bytes()
+}
+
+func smallJpeg(w int, h int) []byte {
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil)
+	return buf.Bytes()
+}
+
+func TestRawPreview(t *testing.T) {
+	large := smallJpeg(60, 40)
+	raw := fakeRaw(smallJpeg(16, 8), large)
+	if !IsRaw("a/IMG_1.NEF", raw) || IsRaw("a/scan.tif", raw) {
+		t.Error("RAW files should be detected by name")
+	}
+	if !IsRaw("a/scan.tif", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00")) {
+		t.Error("CR2 files should be detected by content")
+	}
+
+	preview, err := RawPreview(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(preview, large) {
+		t.Error("the largest preview should be extracted")
+	}
+
+	if _, err := RawPreview(bytes.NewReader(fakeRaw(nil, nil))); err == nil {
+		t.Error("no preview expected")
+	}
+	if _, err := RawPreview(bytes.NewReader([]byte("not a raw"))); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestSiblings(t *testing.T) {
+	if siblings := JpegSiblings("a/IMG_1.CR2"); !reflect.DeepEqual(siblings, []string{"a/IMG_1.jpg", "a/IMG_1.JPG", "a/IMG_1.jpeg", "a/IMG_1.JPEG"}) {
+		t.Error("wrong JPEG siblings", siblings)
+	}
+	siblings := RawSiblings("a/IMG_1.jpg")
+	if len(siblings) != 8 || siblings[0] != "a/IMG_1.arw" || siblings[1] != "a/IMG_1.ARW" {
+		t.Error("wrong RAW siblings", siblings)
+	}
+	if MimeType(FormatRaw, "a/IMG_1.CR2") != "image/x-canon-cr2" || MimeType(FormatRaw, "a/IMG_1") != "application/octet-stream" {
+		t.Error("wrong RAW MIME types")
+	}
+}
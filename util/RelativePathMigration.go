@@ -0,0 +1,54 @@
+package util
+
+import (
+	"log"
+	"path"
+)
+
+// MigrateToRelativePaths renames the photos stored before photos were keyed
+// by their path relative to the source folder. A photo stored under a bare
+// file name that is not at the root of the source folder is renamed to the
+// only path of names having that file name. names are the slash separated
+// relative paths of the source files.
+//
+// It returns the renamed photos, old name to new name.
+func MigrateToRelativePaths(store PhotoStore, names []string) (map[string]string, error) {
+	present := map[string]bool{}
+	candidates := map[string][]string{}
+	for _, name := range names {
+		present[name] = true
+		if base := path.Base(name); base != name {
+			candidates[base] = append(candidates[base], name)
+		}
+	}
+
+	renamed := map[string]string{}
+	for _, photo := range store.GetAll() {
+		if present[photo.Filename] || path.Base(photo.Filename) != photo.Filename {
+			continue
+		}
+		switch len(candidates[photo.Filename]) {
+		case 0:
+			continue
+		case 1:
+		default:
+			log.Printf("Can't migrate %s, found in %v\n", photo.Filename, candidates[photo.Filename])
+			continue
+		}
+
+		// the new photo is added before the old one is removed, so that a
+		// crash in between leaves both, the next run only removing the old
+		migrated := photo
+		migrated.Filename = candidates[photo.Filename][0]
+		if _, err := store.Get(migrated.Filename); err != nil {
+			if err := store.Add(migrated); err != nil {
+				return renamed, err
+			}
+		}
+		if err := store.Remove(photo); err != nil {
+			return renamed, err
+		}
+		renamed[photo.Filename] = migrated.Filename
+	}
+	return renamed, nil
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestMigrateToRelativePaths(t *testing.T) {
+	jsonFilePhotoStore := JsonFilePhotoStore{FileName: filename}
+	jsonFilePhotoStore.RemoveStorageFile()
+	if err := jsonFilePhotoStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+	jsonFilePhotoStore.Add(photoFixture("root.jpg"))
+	jsonFilePhotoStore.Add(photoFixture("moved.jpg"))
+	jsonFilePhotoStore.Add(photoFixture("twice.jpg"))
+	jsonFilePhotoStore.Add(photoFixture("gone.jpg"))
+	// left by a migration interrupted between adding and removing
+	jsonFilePhotoStore.Add(photoFixture("half.jpg"))
+	jsonFilePhotoStore.Add(photoFixture("2016/half.jpg"))
+
+	names := []string{"root.jpg", "2015/moved.jpg", "a/twice.jpg", "b/twice.jpg", "2016/half.jpg"}
+	renamed, err := MigrateToRelativePaths(&jsonFilePhotoStore, names)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(renamed) != 2 || renamed["moved.jpg"] != "2015/moved.jpg" || renamed["half.jpg"] != "2016/half.jpg" {
+		t.Error("only moved.jpg and half.jpg should be renamed", renamed)
+	}
+
+	// the migration is journaled like any other change
+	jsonFilePhotoStore = JsonFilePhotoStore{FileName: filename}
+	if err := jsonFilePhotoStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jsonFilePhotoStore.Get("2015/moved.jpg"); err != nil {
+		t.Error(err)
+	}
+	if _, err := jsonFilePhotoStore.Get("moved.jpg"); err == nil {
+		t.Error("moved.jpg should be renamed")
+	}
+	if _, err := jsonFilePhotoStore.Get("half.jpg"); err == nil {
+		t.Error("half.jpg should be removed")
+	}
+	if _, err := jsonFilePhotoStore.Get("2016/half.jpg"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"root.jpg", "twice.jpg", "gone.jpg"} {
+		if _, err := jsonFilePhotoStore.Get(name); err != nil {
+			t.Error(name, "should be left untouched")
+		}
+	}
+	jsonFilePhotoStore.RemoveStorageFile()
+}
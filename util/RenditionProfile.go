@@ -0,0 +1,242 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var knownFormats = map[string]bool{"jpeg": true, "png": true, "webp": true, "avif": true}
+
+// renditionVersion must be increased when the rendering changes in a way
+// that makes the existing renditions stale.
+const renditionVersion int = 1
+
+// legacyRenditionVersion is the rendering of the versions which uploaded
+// renditions without their hash.
+const legacyRenditionVersion int = 1
+
+// legacyProfiles are the renditions uploaded without their hash, by the
+// versions which only had a thumb and a medium.
+var legacyProfiles = []RenditionProfile{
+	{Name: "thumb", Height: 162, Fit: FitContain, Format: "jpeg", Quality: 75},
+	{Name: "medium", Height: 768, Fit: FitContain, Format: "jpeg", Quality: 75},
+}
+
+const (
+	// FitContain scales the image down to fit inside the bounding box.
+	FitContain string = "contain"
+	// FitCover scales the image to cover the bounding box, then crops the
+	// overflow around the center.
+	FitCover string = "cover"
+)
+
+// RenditionProfile describes a resized version of the photos, stored in S3
+// under Path.
+type RenditionProfile struct {
+	Name string
+	// Width and Height bound the rendition, 0 meaning unbounded. Images are
+	// never enlarged.
+	Width   uint
+	Height  uint
+	Fit     string
+	Format  string
+	Quality int
+	Path    string
+	// AlternativeOf is the name of the profile this one renders in another
+	// format, for browsers supporting it.
+	AlternativeOf string
+}
+
+// ContentType returns the MIME type of the renditions.
+func (profile RenditionProfile) ContentType() string {
+	return "image/" + profile.Format
+}
+
+// Key returns the S3 key of the rendition of fileName. Formats other than
+// jpeg get their extension appended, as alternatives share the path of
+// their profile.
+func (profile RenditionProfile) Key(fileName string) string {
+	if profile.Format == "jpeg" {
+		return profile.Path + fileName
+	}
+	return profile.Path + fileName + "." + profile.Format
+}
+
+// Hash identifies the settings the renditions are rendered with. It changes
+// when the profile or the rendering changes, the renditions are then stale.
+func (profile RenditionProfile) Hash() string {
+	return profile.hash(renditionVersion)
+}
+
+func (profile RenditionProfile) hash(version int) string {
+	settings := fmt.Sprintf("%d %d %d %s %s %d", version, profile.Width, profile.Height, profile.Fit, profile.Format, profile.Quality)
+	sum := sha1.Sum([]byte(settings))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// MatchesHash tells whether a rendition stored with hash was rendered with
+// the settings of profile. Renditions without hash were uploaded by older
+// versions, with the settings of the legacy thumb and medium.
+func (profile RenditionProfile) MatchesHash(hash string) bool {
+	if hash != "" {
+		return hash == profile.Hash()
+	}
+	for _, legacy := range legacyProfiles {
+		if legacy.Name == profile.Name && legacy.hash(legacyRenditionVersion) == profile.Hash() {
+			return true
+		}
+	}
+	return false
+}
+
+// RenditionsHash combines the hashes of all the profiles, to tell at once
+// whether the renditions of a photo are up to date.
+func RenditionsHash(profiles []RenditionProfile) string {
+	h := sha1.New()
+	for _, profile := range profiles {
+		fmt.Fprintf(h, "%s %s %s\n", profile.Name, profile.Path, profile.Hash())
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// ParseRenditionProfiles parses profiles separated by ";", each made of a
+// name followed by space separated key=value settings, for instance
+// "thumb height=162 path=thumb/; large width=2048 height=2048 quality=90 path=large/".
+//
+// Keys are width, height, fit (contain or cover), format (jpeg, png, webp
+// or avif), quality (1 to 100, ignored by png), path and formats, a comma
+// separated list of alternative formats. Fit defaults to contain, format to
+// jpeg and quality to 75.
+//
+// Each alternative format adds a profile named <name>-<format>, for
+// instance "medium height=768 formats=avif,webp path=medium/" adds
+// medium-avif and medium-webp.
+func ParseRenditionProfiles(s string) ([]RenditionProfile, error) {
+	profiles := []RenditionProfile{}
+	names := map[string]bool{}
+	for _, definition := range strings.Split(s, ";") {
+		fields := strings.Fields(definition)
+		if len(fields) == 0 {
+			continue
+		}
+		profile := RenditionProfile{Name: fields[0], Fit: FitContain, Format: "jpeg", Quality: 75}
+		alternatives := []string{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New("invalid setting " + field + " for rendition " + profile.Name)
+			}
+			var err error
+			switch kv[0] {
+			case "width":
+				profile.Width, err = parseDimension(kv[1])
+			case "height":
+				profile.Height, err = parseDimension(kv[1])
+			case "fit":
+				profile.Fit = kv[1]
+			case "format":
+				profile.Format = kv[1]
+			case "quality":
+				profile.Quality, err = strconv.Atoi(kv[1])
+			case "path":
+				profile.Path = kv[1]
+			case "formats":
+				alternatives = strings.Split(kv[1], ",")
+			default:
+				err = errors.New("unknown setting " + kv[0])
+			}
+			if err != nil {
+				return nil, errors.New("rendition " + profile.Name + " : " + err.Error())
+			}
+		}
+		expanded := []RenditionProfile{profile}
+		for _, format := range alternatives {
+			alternative := profile
+			alternative.Name = profile.Name + "-" + format
+			alternative.Format = format
+			alternative.AlternativeOf = profile.Name
+			expanded = append(expanded, alternative)
+		}
+		for _, profile := range expanded {
+			if err := profile.validate(); err != nil {
+				return nil, errors.New("rendition " + profile.Name + " : " + err.Error())
+			}
+			if names[profile.Name] {
+				return nil, errors.New("duplicate rendition " + profile.Name)
+			}
+			names[profile.Name] = true
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles, nil
+}
+
+// Alternatives returns the profiles rendering the profile named name in
+// other formats.
+func Alternatives(profiles []RenditionProfile, name string) []RenditionProfile {
+	alternatives := []RenditionProfile{}
+	for _, profile := range profiles {
+		if profile.AlternativeOf == name {
+			alternatives = append(alternatives, profile)
+		}
+	}
+	return alternatives
+}
+
+func parseDimension(s string) (uint, error) {
+	d, err := strconv.ParseUint(s, 10, 32)
+	return uint(d), err
+}
+
+func (profile RenditionProfile) validate() error {
+	switch {
+	case profile.Width == 0 && profile.Height == 0:
+		return errors.New("width or height required")
+	case profile.Fit != FitContain && profile.Fit != FitCover:
+		return errors.New("unknown fit " + profile.Fit)
+	case profile.Fit == FitCover && (profile.Width == 0 || profile.Height == 0):
+		return errors.New("cover needs both width and height")
+	case !knownFormats[profile.Format]:
+		return errors.New("unknown format " + profile.Format)
+	case profile.Quality < 1 || profile.Quality > 100:
+		return errors.New("quality must be between 1 and 100")
+	case profile.Path == "":
+		return errors.New("path required")
+	}
+	return nil
+}
+
+// scaledSize returns the size a w x h image is resized to, before the crop
+// of the cover fit.
+func (profile RenditionProfile) scaledSize(w int, h int) (int, int) {
+	scaleW := float64(profile.Width) / float64(w)
+	scaleH := float64(profile.Height) / float64(h)
+	var scale float64
+	switch {
+	case profile.Width == 0:
+		scale = scaleH
+	case profile.Height == 0:
+		scale = scaleW
+	case profile.Fit == FitCover:
+		scale = math.Max(scaleW, scaleH)
+	default:
+		scale = math.Min(scaleW, scaleH)
+	}
+	if scale >= 1 {
+		return w, h
+	}
+	return scaleDimension(w, scale), scaleDimension(h, scale)
+}
+
+func scaleDimension(d int, scale float64) int {
+	scaled := int(float64(d)*scale + 0.5)
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
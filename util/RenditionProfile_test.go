@@ -0,0 +1,125 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseRenditionProfiles(t *testing.T) {
+	profiles, err := ParseRenditionProfiles("thumb height=162 path=p/thumb/ ; square width=200 height=200 fit=cover format=png path=p/square/;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RenditionProfile{
+		{Name: "thumb", Height: 162, Fit: FitContain, Format: "jpeg", Quality: 75, Path: "p/thumb/"},
+		{Name: "square", Width: 200, Height: 200, Fit: FitCover, Format: "png", Quality: 75, Path: "p/square/"},
+	}
+	if len(profiles) != len(expected) {
+		t.Fatal("wrong number of profiles", len(profiles))
+	}
+	for i := range expected {
+		if profiles[i] != expected[i] {
+			t.Error("wrong profile", profiles[i])
+		}
+	}
+	if profiles[1].ContentType() != "image/png" {
+		t.Error("wrong content type", profiles[1].ContentType())
+	}
+
+	for _, invalid := range []string{
+		"thumb path=p/",
+		"thumb height=162",
+		"thumb height=abc path=p/",
+		"thumb width=10 fit=cover path=p/",
+		"thumb height=162 format=gif path=p/",
+		"thumb height=162 quality=0 path=p/",
+		"thumb height=162 size=2 path=p/",
+		"thumb height=162 path=p/; thumb height=10 path=q/",
+	} {
+		if _, err := ParseRenditionProfiles(invalid); err == nil {
+			t.Error("error expected for", invalid)
+		}
+	}
+}
+
+func TestScaledSize(t *testing.T) {
+	cases := []struct {
+		profile RenditionProfile
+		w, h    int
+	}{
+		{RenditionProfile{Height: 150}, 200, 150},
+		{RenditionProfile{Width: 200}, 200, 150},
+		{RenditionProfile{Width: 200, Height: 200}, 200, 150},
+		{RenditionProfile{Width: 200, Height: 200, Fit: FitCover}, 267, 200},
+		{RenditionProfile{Height: 1000}, 400, 300},
+	}
+	for _, c := range cases {
+		if w, h := c.profile.scaledSize(400, 300); w != c.w || h != c.h {
+			t.Error("wrong size for", c.profile, w, h)
+		}
+	}
+}
+
+func TestRenditionHash(t *testing.T) {
+	thumb := RenditionProfile{Name: "thumb", Height: 162, Fit: FitContain, Format: "jpeg", Quality: 75, Path: "thumb/"}
+	medium := RenditionProfile{Name: "medium", Height: 768, Fit: FitContain, Format: "jpeg", Quality: 75, Path: "medium/"}
+	moved := thumb
+	moved.Path = "thumbnails/"
+	if thumb.Hash() != moved.Hash() {
+		t.Error("the path should not change the hash of the renditions")
+	}
+	better := thumb
+	better.Quality = 90
+	if thumb.Hash() == better.Hash() {
+		t.Error("the quality should change the hash of the renditions")
+	}
+
+	all := RenditionsHash([]RenditionProfile{thumb, medium})
+	if all != RenditionsHash([]RenditionProfile{thumb, medium}) {
+		t.Error("hash should be stable")
+	}
+	for _, changed := range [][]RenditionProfile{{thumb}, {better, medium}, {moved, medium}} {
+		if RenditionsHash(changed) == all {
+			t.Error("hash should change with the profiles", changed)
+		}
+	}
+
+	if !thumb.MatchesHash(thumb.Hash()) || thumb.MatchesHash(better.Hash()) {
+		t.Error("renditions should match the hash of their profile")
+	}
+	if !thumb.MatchesHash("") || !moved.MatchesHash("") || !medium.MatchesHash("") {
+		t.Error("renditions without hash should match the legacy thumb and medium")
+	}
+	large := RenditionProfile{Name: "large", Height: 768, Fit: FitContain, Format: "jpeg", Quality: 75, Path: "large/"}
+	if better.MatchesHash("") || large.MatchesHash("") {
+		t.Error("renditions without hash are stale for other profiles")
+	}
+}
+
+func TestParseRenditionAlternatives(t *testing.T) {
+	profiles, err := ParseRenditionProfiles("medium height=768 formats=avif,webp path=medium/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 3 || profiles[1].Name != "medium-avif" || profiles[2].Name != "medium-webp" {
+		t.Fatal("wrong profiles", profiles)
+	}
+	alternatives := Alternatives(profiles, "medium")
+	if len(alternatives) != 2 || alternatives[0].Format != "avif" || alternatives[1].ContentType() != "image/webp" {
+		t.Error("wrong alternatives", alternatives)
+	}
+	if profiles[0].Key("a/b.jpg") != "medium/a/b.jpg" || profiles[1].Key("a/b.jpg") != "medium/a/b.jpg.avif" {
+		t.Error("wrong keys", profiles[0].Key("a/b.jpg"), profiles[1].Key("a/b.jpg"))
+	}
+	if profiles[0].Hash() == profiles[2].Hash() {
+		t.Error("the format should change the hash of the renditions")
+	}
+
+	for _, invalid := range []string{
+		"medium height=768 formats=gif path=medium/",
+		"medium height=768 formats=webp path=medium/; medium-webp height=10 path=small/",
+	} {
+		if _, err := ParseRenditionProfiles(invalid); err == nil {
+			t.Error("error expected for", invalid)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"bytes"
+	"github.com/nfnt/resize"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// RenderRenditions decodes an image once, rotates and flips it according to
+// its EXIF orientation, and encodes it for each of the profiles. Renditions
+// are returned in the order of profiles, along with the PerceptualHash of
+// the image.
+//
+// The largest rendition is resized from the decoded image and each smaller
+// one from the previous (uncropped) rendition, so that only one full size
+// image is held in memory and the following resizes are cheap. The hash is
+// computed from the smallest one.
+func RenderRenditions(r io.Reader, orientation int, profiles []RenditionProfile) ([][]byte, string, error) {
+	img, _, err := DecodeImage(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return RenderImage(ApplyOrientation(img, orientation), profiles)
+}
+
+// RenderImage encodes an already decoded image for each of the profiles,
+// as RenderRenditions.
+func RenderImage(img image.Image, profiles []RenditionProfile) ([][]byte, string, error) {
+	var err error
+	bounds := img.Bounds()
+
+	type size struct{ w, h int }
+	sizes := make([]size, len(profiles))
+	order := make([]int, len(profiles))
+	for i, profile := range profiles {
+		w, h := profile.scaledSize(bounds.Dx(), bounds.Dy())
+		sizes[i] = size{w, h}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]].w*sizes[order[i]].h > sizes[order[j]].w*sizes[order[j]].h
+	})
+
+	renditions := make([][]byte, len(profiles))
+	for _, i := range order {
+		profile := profiles[i]
+		if img.Bounds().Dx() != sizes[i].w || img.Bounds().Dy() != sizes[i].h {
+			// resize using Lanczos resampling
+			img = resize.Resize(uint(sizes[i].w), uint(sizes[i].h), img, resize.Lanczos3)
+		}
+		rendition := img
+		if profile.Fit == FitCover {
+			rendition = cropCenter(img, int(profile.Width), int(profile.Height))
+		}
+		buf := bytes.Buffer{}
+		switch profile.Format {
+		case "jpeg":
+			err = jpeg.Encode(&buf, flatten(rendition), &jpeg.Options{Quality: profile.Quality})
+		case "png":
+			err = png.Encode(&buf, rendition)
+		default:
+			err = encodeExternal(&buf, rendition, profile.Format, profile.Quality)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		renditions[i] = buf.Bytes()
+	}
+	return renditions, PerceptualHash(img), nil
+}
+
+// cropCenter crops img to w x h around its center, or less if img is
+// smaller.
+func cropCenter(img image.Image, w int, h int) image.Image {
+	bounds := img.Bounds()
+	if w >= bounds.Dx() && h >= bounds.Dy() {
+		return img
+	}
+	if w > bounds.Dx() {
+		w = bounds.Dx()
+	}
+	if h > bounds.Dy() {
+		h = bounds.Dy()
+	}
+	x := bounds.Min.X + (bounds.Dx()-w)/2
+	y := bounds.Min.Y + (bounds.Dy()-h)/2
+	cropped := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(cropped, cropped.Bounds(), img, image.Point{x, y}, draw.Src)
+	return cropped
+}
+
+// flatten draws an image having transparent parts over a white background,
+// as JPEG has no transparency.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+	return flat
+}
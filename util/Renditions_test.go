@@ -0,0 +1,183 @@
+package util
+
+import (
+	"bytes"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// largeJpeg generates a w x h JPEG with some detail to compress.
+func largeJpeg(w int, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x * y), 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, img, nil)
+	return buf.Bytes()
+}
+
+// heightProfiles returns jpeg contain profiles bounded by the heights.
+func heightProfiles(heights ...uint) []RenditionProfile {
+	profiles := []RenditionProfile{}
+	for _, height := range heights {
+		profiles = append(profiles, RenditionProfile{Height: height, Fit: FitContain, Format: "jpeg", Quality: 75})
+	}
+	return profiles
+}
+
+func TestRenderRenditions(t *testing.T) {
+	renditions, _, err := RenderRenditions(bytes.NewReader(largeJpeg(400, 300)), 6, heightProfiles(40, 160))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != 2 {
+		t.Fatal("2 renditions expected")
+	}
+	for i, height := range []int{40, 160} {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(renditions[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// rotated: 300x400
+		if config.Height != height || config.Width != height*3/4 {
+			t.Error("wrong rendition size", config.Width, config.Height)
+		}
+	}
+
+	if _, _, err := RenderRenditions(strings.NewReader("not a jpeg"), 1, heightProfiles(30)); err == nil {
+		t.Error("decoding should fail")
+	}
+}
+
+func TestRenderRenditionsFit(t *testing.T) {
+	profiles := []RenditionProfile{
+		{Width: 100, Height: 100, Fit: FitCover, Format: "png", Quality: 75},
+		{Width: 100, Height: 100, Fit: FitContain, Format: "jpeg", Quality: 75},
+		{Width: 2048, Height: 2048, Fit: FitContain, Format: "jpeg", Quality: 90},
+	}
+	renditions, _, err := RenderRenditions(bytes.NewReader(largeJpeg(400, 300)), 1, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []image.Point{{100, 100}, {100, 75}, {400, 300}}
+	for i, size := range expected {
+		config, _, err := image.DecodeConfig(bytes.NewReader(renditions[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != size.X || config.Height != size.Y {
+			t.Error("wrong rendition size", i, config.Width, config.Height)
+		}
+	}
+	if _, err := png.DecodeConfig(bytes.NewReader(renditions[0])); err != nil {
+		t.Error("png expected", err)
+	}
+}
+
+func TestRenderRenditionsExternal(t *testing.T) {
+	// fake cwebp, copying the PNG it is given
+	bin, err := ioutil.TempDir("", "gogal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bin)
+	script := "#!/bin/sh\n/bin/cp \"$4\" \"$6\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "cwebp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin)
+
+	if !EncoderAvailable("webp") || EncoderAvailable("avif") || !EncoderAvailable("jpeg") {
+		t.Fatal("wrong encoders available")
+	}
+	profiles := []RenditionProfile{{Height: 30, Fit: FitContain, Format: "webp", Quality: 75}}
+	renditions, _, err := RenderRenditions(bytes.NewReader(largeJpeg(400, 300)), 1, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, err := png.DecodeConfig(bytes.NewReader(renditions[0])); err != nil || config.Height != 30 {
+		t.Error("the encoder should get the resized image", err)
+	}
+
+	profiles[0].Format = "avif"
+	if _, _, err := RenderRenditions(bytes.NewReader(largeJpeg(400, 300)), 1, profiles); err == nil {
+		t.Error("encoding should fail without avifenc")
+	}
+}
+
+// benchmarkJpegs returns the JPEGs of the folder GOGAL_BENCH_FOLDER, or a
+// few generated 12 megapixels JPEGs.
+func benchmarkJpegs(b *testing.B) [][]byte {
+	folder := os.Getenv("GOGAL_BENCH_FOLDER")
+	if folder == "" {
+		jpg := largeJpeg(4000, 3000)
+		return [][]byte{jpg, jpg, jpg}
+	}
+	paths, _ := filepath.Glob(filepath.Join(folder, "*.[jJ][pP][gG]"))
+	jpegs := [][]byte{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		jpegs = append(jpegs, content)
+	}
+	if len(jpegs) == 0 {
+		b.Skip("no JPEG in", folder)
+	}
+	return jpegs
+}
+
+func totalSize(jpegs [][]byte) int64 {
+	size := 0
+	for _, jpg := range jpegs {
+		size += len(jpg)
+	}
+	return int64(size)
+}
+
+func BenchmarkRenderRenditions(b *testing.B) {
+	jpegs := benchmarkJpegs(b)
+	b.SetBytes(totalSize(jpegs))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, jpg := range jpegs {
+			if _, _, err := RenderRenditions(bytes.NewReader(jpg), 1, heightProfiles(162, 768)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkRenderSeparately is the previous pipeline, decoding the JPEG
+// again for each rendition, for comparison.
+func BenchmarkRenderSeparately(b *testing.B) {
+	jpegs := benchmarkJpegs(b)
+	b.SetBytes(totalSize(jpegs))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, jpg := range jpegs {
+			for _, height := range []uint{162, 768} {
+				img, err := jpeg.Decode(bytes.NewReader(jpg))
+				if err != nil {
+					b.Fatal(err)
+				}
+				jpeg.Encode(ioutil.Discard, resize.Resize(0, height, img, resize.Lanczos3), nil)
+			}
+		}
+	}
+}
@@ -6,21 +6,31 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
 	"log"
+	"net/url"
 	"sort"
+	"strings"
 	"sync"
 )
 
 const dataType string = "image/jpeg"
+
+// renditionHashKey is the S3 metadata holding the hash of the profile an
+// object was rendered with.
+const renditionHashKey = "Rendition-Hash"
 const s3RootUrl = "https://s3.amazonaws.com"
 
+// maxDeleteKeys is the most keys a DeleteObjects request accepts.
+const maxDeleteKeys = 1000
+
 type S3Manager struct {
 	Bucket              string
 	Region              string
 	ImagePath           string
-	ThumbPath           string
-	MediumPath          string
+	Renditions          []RenditionProfile
+	TrashPath           string
 	mutex               *sync.Mutex
 	existingFiles       []string
+	listed              bool
 	NbConcurrentUploads int
 	svc                 *s3.S3
 	queue               chan (bool)
@@ -42,26 +52,45 @@ func (manager *S3Manager) Connect() error {
 	return err
 }
 
-func (manager S3Manager) UploadImage(rs io.ReadSeeker, fileName string) (url string, err error) {
-	return manager.upload(rs, fileName, manager.ImagePath, "image")
+// UploadImage uploads an original image. contentType defaults to JPEG, the
+// only format supported by older versions.
+func (manager *S3Manager) UploadImage(rs io.ReadSeeker, fileName string, contentType string) (url string, err error) {
+	if contentType == "" {
+		contentType = dataType
+	}
+	return manager.upload(rs, fileName, manager.ImagePath+fileName, "image", contentType, nil)
 }
 
-func (manager S3Manager) UploadThumb(rs io.ReadSeeker, fileName string) (url string, err error) {
-	return manager.upload(rs, fileName, manager.ThumbPath, "thumb")
+func (manager *S3Manager) UploadRendition(rs io.ReadSeeker, fileName string, profile RenditionProfile) (url string, err error) {
+	metadata := map[string]*string{renditionHashKey: aws.String(profile.Hash())}
+	return manager.upload(rs, fileName, profile.Key(fileName), profile.Name, profile.ContentType(), metadata)
 }
 
-func (manager S3Manager) UploadMedium(rs io.ReadSeeker, fileName string) (url string, err error) {
-	return manager.upload(rs, fileName, manager.MediumPath, "medium")
+// RenditionHash returns the hash of the profile the rendition of fileName
+// was rendered with, or "" if it is unknown (uploaded by older versions).
+func (manager *S3Manager) RenditionHash(fileName string, profile RenditionProfile) (string, error) {
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(manager.Bucket),        // Required
+		Key:    aws.String(profile.Key(fileName)), // Required
+	}
+	resp, err := manager.svc.HeadObject(params)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range resp.Metadata {
+		if strings.EqualFold(key, renditionHashKey) {
+			return aws.StringValue(value), nil
+		}
+	}
+	return "", nil
 }
 
-func (manager S3Manager) upload(rs io.ReadSeeker, fileName string, path string, imageType string) (url string, err error) {
+func (manager *S3Manager) upload(rs io.ReadSeeker, fileName string, filePath string, imageType string, contentType string, metadata map[string]*string) (url string, err error) {
 	defer func() { <-manager.queue }()
 	manager.queue <- true
 
 	log.Printf("Uploading %s %s", imageType, fileName)
 
-	filePath := path + fileName
-
 	if manager.svc == nil {
 		return "", errors.New("S3Manager not initialized, Connect should be called first")
 	}
@@ -70,35 +99,147 @@ func (manager S3Manager) upload(rs io.ReadSeeker, fileName string, path string,
 		Bucket:      aws.String(manager.Bucket), // Required
 		Key:         aws.String(filePath),       // Required
 		Body:        rs,
-		ContentType: aws.String(dataType),
+		ContentType: aws.String(contentType),
+		Metadata:    metadata,
 	}
 
 	resp, err := manager.svc.PutObject(params)
+	if err != nil {
+		return "", err
+	}
+	manager.addExistingFile(filePath)
 
 	log.Printf("%s %s successfully uploaded", imageType, fileName)
 
 	return resp.String(), err
 }
 
-func (manager *S3Manager) ExistsImage(fileName string) (exists bool, err error) {
-	return manager.exists(fileName, manager.ImagePath)
+// CopyFiles copies the image and renditions of fromFileName, when they
+// exist, to the keys of toFileName.
+func (manager *S3Manager) CopyFiles(fromFileName string, toFileName string) error {
+	keys := map[string]string{manager.ImagePath + fromFileName: manager.ImagePath + toFileName}
+	for _, profile := range manager.Renditions {
+		keys[profile.Key(fromFileName)] = profile.Key(toFileName)
+	}
+	for fromKey, toKey := range keys {
+		exists, err := manager.exists(fromKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := manager.copyObject(fromKey, toKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (manager *S3Manager) copyObject(fromKey string, toKey string) error {
+	source := url.URL{Path: manager.Bucket + "/" + fromKey}
+	params := &s3.CopyObjectInput{
+		Bucket:     aws.String(manager.Bucket),       // Required
+		CopySource: aws.String(source.EscapedPath()), // Required
+		Key:        aws.String(toKey),                // Required
+	}
+	if _, err := manager.svc.CopyObject(params); err != nil {
+		return err
+	}
+	manager.addExistingFile(toKey)
+	return nil
+}
+
+// FileKeys returns the keys of the image and renditions of fileName which
+// exist.
+func (manager *S3Manager) FileKeys(fileName string) ([]string, error) {
+	candidates := []string{manager.ImagePath + fileName}
+	for _, profile := range manager.Renditions {
+		candidates = append(candidates, profile.Key(fileName))
+	}
+	keys := []string{}
+	for _, key := range candidates {
+		exists, err := manager.exists(key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// TrashObjects moves the objects of keys under TrashPath, keeping their
+// keys after it.
+func (manager *S3Manager) TrashObjects(keys []string) error {
+	if manager.TrashPath == "" {
+		return errors.New("no trash path")
+	}
+	for _, key := range keys {
+		if err := manager.copyObject(key, manager.TrashPath+key); err != nil {
+			return err
+		}
+	}
+	return manager.DeleteObjects(keys)
 }
 
-func (manager *S3Manager) ExistsThumb(fileName string) (exists bool, err error) {
-	return manager.exists(fileName, manager.ThumbPath)
+// DeleteObjects deletes the objects of keys, by batches of maxDeleteKeys.
+func (manager *S3Manager) DeleteObjects(keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteKeys {
+		end := start + maxDeleteKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := []*s3.ObjectIdentifier{}
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		params := &s3.DeleteObjectsInput{
+			Bucket: aws.String(manager.Bucket), // Required
+			Delete: &s3.Delete{ // Required
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		}
+		resp, err := manager.svc.DeleteObjects(params)
+		if err != nil {
+			return err
+		}
+		failed := map[string]bool{}
+		for _, deleteError := range resp.Errors {
+			failed[aws.StringValue(deleteError.Key)] = true
+			log.Printf("Can't delete %s : %s\n", aws.StringValue(deleteError.Key), aws.StringValue(deleteError.Message))
+		}
+		for _, key := range keys[start:end] {
+			if !failed[key] {
+				manager.removeExistingFile(key)
+			}
+		}
+		if len(resp.Errors) > 0 {
+			return errors.New("some objects could not be deleted")
+		}
+	}
+	return nil
+}
+
+func (manager *S3Manager) ExistsImage(fileName string) (exists bool, err error) {
+	return manager.exists(manager.ImagePath + fileName)
 }
 
-func (manager *S3Manager) ExistsMedium(fileName string) (exists bool, err error) {
-	return manager.exists(fileName, manager.MediumPath)
+func (manager *S3Manager) ExistsRendition(fileName string, profile RenditionProfile) (exists bool, err error) {
+	return manager.exists(profile.Key(fileName))
 }
 
-func (manager *S3Manager) exists(fileName string, path string) (exists bool, err error) {
+func (manager *S3Manager) exists(filePath string) (exists bool, err error) {
 	initError := manager.initExistingFiles()
 	if initError != nil {
 		return false, initError
 	}
 
-	filePath := path + fileName
+	defer manager.mutex.Unlock()
+	manager.mutex.Lock()
+
 	i := sort.Search(len(manager.existingFiles), func(i int) bool { return manager.existingFiles[i] >= filePath })
 	if i < len(manager.existingFiles) && manager.existingFiles[i] == filePath {
 		return true, nil
@@ -107,12 +248,36 @@ func (manager *S3Manager) exists(fileName string, path string) (exists bool, err
 	return false, nil
 }
 
+func (manager *S3Manager) addExistingFile(filePath string) {
+	defer manager.mutex.Unlock()
+	manager.mutex.Lock()
+
+	i := sort.SearchStrings(manager.existingFiles, filePath)
+	if i < len(manager.existingFiles) && manager.existingFiles[i] == filePath {
+		return
+	}
+	manager.existingFiles = append(manager.existingFiles, "")
+	copy(manager.existingFiles[i+1:], manager.existingFiles[i:])
+	manager.existingFiles[i] = filePath
+}
+
+func (manager *S3Manager) removeExistingFile(filePath string) {
+	defer manager.mutex.Unlock()
+	manager.mutex.Lock()
+
+	i := sort.SearchStrings(manager.existingFiles, filePath)
+	if i < len(manager.existingFiles) && manager.existingFiles[i] == filePath {
+		manager.existingFiles = append(manager.existingFiles[:i], manager.existingFiles[i+1:]...)
+	}
+}
+
 func (manager *S3Manager) initExistingFiles() error {
 	defer manager.mutex.Unlock()
 	manager.mutex.Lock()
 
-	if len(manager.existingFiles) == 0 {
+	if !manager.listed {
 		log.Printf("Retrieving all images from S3")
+		manager.existingFiles = nil
 		params := &s3.ListObjectsInput{
 			Bucket:  aws.String(manager.Bucket), // Required
 			MaxKeys: aws.Int64(1000),
@@ -127,11 +292,30 @@ func (manager *S3Manager) initExistingFiles() error {
 			return err
 		}
 		sort.Strings(manager.existingFiles)
+		manager.listed = true
 		log.Printf("%d images retrieved from S3", len(manager.existingFiles))
 	}
 	return nil
 }
 
+// ListObjects returns the size of the objects whose key starts with prefix,
+// by key.
+func (manager *S3Manager) ListObjects(prefix string) (map[string]int64, error) {
+	objects := map[string]int64{}
+	params := &s3.ListObjectsInput{
+		Bucket:  aws.String(manager.Bucket), // Required
+		MaxKeys: aws.Int64(1000),
+		Prefix:  aws.String(prefix),
+	}
+	err := manager.svc.ListObjectsPages(params, func(p *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, object := range p.Contents {
+			objects[*object.Key] = aws.Int64Value(object.Size)
+		}
+		return true
+	})
+	return objects, err
+}
+
 func (manager S3Manager) BucketURL() string {
 	return manager.svc.Endpoint + "/" + manager.Bucket + "/"
 }
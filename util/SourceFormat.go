@@ -0,0 +1,203 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Source formats, as detected by DetectFormat.
+const (
+	FormatJpeg string = "jpeg"
+	FormatPng  string = "png"
+	FormatGif  string = "gif"
+	FormatTiff string = "tiff"
+	FormatHeic string = "heic"
+)
+
+var mimeTypes = map[string]string{
+	FormatJpeg: "image/jpeg",
+	FormatPng:  "image/png",
+	FormatGif:  "image/gif",
+	FormatTiff: "image/tiff",
+	FormatHeic: "image/heic",
+	FormatMp4:  "video/mp4",
+	FormatMov:  "video/quicktime",
+}
+
+// heicBrands are the ftyp brands of HEIF files holding HEVC images.
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// mp4Brands are the ftyp brands of MP4 videos.
+var mp4Brands = map[string]bool{
+	"isom": true, "iso2": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "dash": true,
+	"3gp4": true, "3gp5": true, "3g2a": true,
+}
+
+// quickTimeAtoms are the atoms older QuickTime files, without ftyp, start
+// with.
+var quickTimeAtoms = map[string]bool{"moov": true, "mdat": true, "wide": true, "free": true, "skip": true}
+
+// DetectFormat sniffs the format of a source file from its first bytes, and
+// returns "" when it is not a supported image or video.
+func DetectFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJpeg
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPng
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return FormatGif
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return FormatTiff
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && heicBrands[string(head[8:12])]:
+		return FormatHeic
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && mp4Brands[string(head[8:12])]:
+		return FormatMp4
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && string(head[8:12]) == "qt  ":
+		return FormatMov
+	case len(head) >= 8 && quickTimeAtoms[string(head[4:8])]:
+		return FormatMov
+	}
+	return ""
+}
+
+// MimeType returns the MIME type of a source file, from its format and for
+// RAW files its name.
+func MimeType(format string, name string) string {
+	if format == FormatRaw {
+		if mimeType, found := rawMimeTypes[strings.ToLower(path.Ext(name))]; found {
+			return mimeType
+		}
+		return "application/octet-stream"
+	}
+	return mimeTypes[format]
+}
+
+// DecoderAvailable tells whether images of format can be decoded, which for
+// heic needs heif-convert in the PATH.
+func DecoderAvailable(format string) bool {
+	if format == FormatHeic {
+		return commandAvailable(heicDecoder)
+	}
+	return mimeTypes[format] != "" || format == FormatRaw
+}
+
+// DecodeImage detects the format of an image and decodes it. Animated GIFs
+// are decoded to their first frame.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	format := DetectFormat(head)
+	var img image.Image
+	var err error
+	switch format {
+	case FormatJpeg:
+		img, err = jpeg.Decode(br)
+	case FormatPng:
+		img, err = png.Decode(br)
+	case FormatGif:
+		img, err = gif.Decode(br)
+	case FormatTiff:
+		img, err = tiff.Decode(br)
+	case FormatHeic:
+		img, err = decodeExternal(br, format)
+	default:
+		err = errors.New("unsupported image format")
+	}
+	return img, format, err
+}
+
+// DecodeConfig returns the dimensions of an image of format, without
+// decoding it, except for HEIC images whose dimensions are taken from the
+// output of heif-convert, rotation and mirroring applied.
+func DecodeConfig(r io.Reader, format string) (image.Config, error) {
+	if format != FormatHeic {
+		config, _, err := image.DecodeConfig(r)
+		return config, err
+	}
+	img, err := decodeExternal(r, format)
+	if err != nil {
+		return image.Config{}, err
+	}
+	bounds := img.Bounds()
+	return image.Config{ColorModel: img.ColorModel(), Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
+
+// DecodeExif finds and parses the EXIF block of an image: in the APP1
+// segment of JPEGs, the IFDs of TIFFs and RAW files, the eXIf chunk of PNGs
+// and the Exif item of HEICs.
+func DecodeExif(rs io.ReadSeeker, format string) (*exif.Exif, error) {
+	switch format {
+	case FormatJpeg, FormatTiff, FormatRaw:
+		rs.Seek(0, io.SeekStart)
+		return exif.Decode(rs)
+	case FormatPng:
+		block, err := pngExif(rs)
+		if err != nil {
+			return nil, err
+		}
+		return exif.Decode(bytes.NewReader(block))
+	case FormatHeic:
+		rs.Seek(0, io.SeekStart)
+		content, err := ioutil.ReadAll(rs)
+		if err != nil {
+			return nil, err
+		}
+		// the Exif item starts with the "Exif\0\0" header, then a TIFF block
+		i := bytes.Index(content, []byte("Exif\x00\x00"))
+		if i < 0 {
+			return nil, errors.New("no EXIF in HEIC")
+		}
+		return exif.Decode(bytes.NewReader(content[i+6:]))
+	}
+	return nil, errors.New("no EXIF in " + format)
+}
+
+// maxPngExifLength bounds the eXIf chunks read, so that a corrupt length
+// doesn't allocate gigabytes.
+const maxPngExifLength int64 = 4 << 20
+
+// pngExif returns the content of the eXIf chunk of a PNG.
+func pngExif(rs io.ReadSeeker) ([]byte, error) {
+	if _, err := rs.Seek(8, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(rs, header); err != nil {
+			return nil, errors.New("no EXIF in PNG")
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "eXIf":
+			if length > maxPngExifLength {
+				return nil, errors.New("eXIf chunk too long in PNG")
+			}
+			block := make([]byte, length)
+			_, err := io.ReadFull(rs, block)
+			return block, err
+		case "IEND":
+			return nil, errors.New("no EXIF in PNG")
+		}
+		// skip the data and the CRC
+		if _, err := rs.Seek(length+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/tiff"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// orientationTiff returns a TIFF block whose IFD only holds an orientation.
+func orientationTiff(orientation uint16) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString("MM\x00*")
+	for _, v := range []interface{}{
+		uint32(8), uint16(1), // IFD offset, 1 entry
+		uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0),
+		uint32(0), // no next IFD
+	} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// pngWithExif returns an encoded PNG with an eXIf chunk before its data.
+func pngWithExif(img image.Image, block []byte) []byte {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	encoded := buf.Bytes()
+	// the IHDR chunk is 8+13+4 bytes long, after the 8 bytes signature
+	chunk := bytes.Buffer{}
+	binary.Write(&chunk, binary.BigEndian, uint32(len(block)))
+	chunk.WriteString("eXIf")
+	chunk.Write(block)
+	binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("eXIf"), block...)))
+	return append(append(append([]byte{}, encoded[:33]...), chunk.Bytes()...), encoded[33:]...)
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"\xFF\xD8\xFF\xE1":                 FormatJpeg,
+		"\x89PNG\r\n\x1a\n":                FormatPng,
+		"GIF89a":                           FormatGif,
+		"II*\x00\x08\x00":                  FormatTiff,
+		"MM\x00*\x00\x00":                  FormatTiff,
+		"\x00\x00\x00\x18ftypheic\x00\x00": FormatHeic,
+		"\x00\x00\x00\x18ftypmif1\x00\x00": FormatHeic,
+		"\x00\x00\x00\x18ftypisom\x00\x00": FormatMp4,
+		"\x00\x00\x00\x14ftypqt  \x00\x00": FormatMov,
+		"\x00\x00\x00\x08wide\x00\x00":     FormatMov,
+		"\x00\x00\x00\x18ftypcrx \x00\x00": "",
+		"<html>":                           "",
+		"":                                 "",
+	}
+	for head, format := range cases {
+		if DetectFormat([]byte(head)) != format {
+			t.Errorf("%q should be detected as %q", head, format)
+		}
+	}
+	if MimeType(FormatHeic, "a.heic") != "image/heic" || MimeType(FormatTiff, "a.tif") != "image/tiff" {
+		t.Error("wrong MIME types")
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	img.Set(1, 1, color.NRGBA{255, 0, 0, 255})
+	encoders := map[string]func(*bytes.Buffer) error{
+		FormatPng:  func(buf *bytes.Buffer) error { return png.Encode(buf, img) },
+		FormatGif:  func(buf *bytes.Buffer) error { return gif.Encode(buf, img, nil) },
+		FormatTiff: func(buf *bytes.Buffer) error { return tiff.Encode(buf, img, nil) },
+	}
+	for format, encode := range encoders {
+		buf := bytes.Buffer{}
+		if err := encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if config, err := DecodeConfig(bytes.NewReader(buf.Bytes()), format); err != nil || config.Width != 30 || config.Height != 20 {
+			t.Error("wrong config for", format, config, err)
+		}
+		decoded, decodedFormat, err := DecodeImage(&buf)
+		if err != nil || decodedFormat != format {
+			t.Error("can't decode", format, decodedFormat, err)
+			continue
+		}
+		if decoded.Bounds().Dx() != 30 || decoded.Bounds().Dy() != 20 {
+			t.Error("wrong size for", format, decoded.Bounds())
+		}
+	}
+	if _, _, err := DecodeImage(bytes.NewReader([]byte("<html>"))); err == nil {
+		t.Error("decoding should fail")
+	}
+}
+
+func TestDecodeExif(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	heic := append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mdat....\x00\x00\x00\x06Exif\x00\x00"), orientationTiff(8)...)
+	sources := []struct {
+		format      string
+		content     []byte
+		orientation int
+	}{
+		{FormatTiff, orientationTiff(3), 3},
+		{FormatPng, pngWithExif(img, orientationTiff(6)), 6},
+		{FormatHeic, heic, 8},
+	}
+	for _, source := range sources {
+		x, err := DecodeExif(bytes.NewReader(source.content), source.format)
+		if err != nil {
+			t.Error("can't decode EXIF of", source.format, err)
+			continue
+		}
+		if orientation := exifInt(x, exif.Orientation); orientation != source.orientation {
+			t.Error("wrong orientation for", source.format, orientation)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	if _, err := DecodeExif(bytes.NewReader(buf.Bytes()), FormatPng); err == nil {
+		t.Error("no EXIF expected")
+	}
+	if _, err := DecodeExif(bytes.NewReader(buf.Bytes()), FormatGif); err == nil {
+		t.Error("no EXIF expected")
+	}
+
+	corrupt := pngWithExif(img, orientationTiff(6))
+	binary.BigEndian.PutUint32(corrupt[33:], 0xFFFFFFFF)
+	if _, err := DecodeExif(bytes.NewReader(corrupt), FormatPng); err == nil {
+		t.Error("an eXIf chunk too long should not be read")
+	}
+}
+
+func TestRenderRenditionsTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	buf := bytes.Buffer{}
+	png.Encode(&buf, img)
+	renditions, _, err := RenderRenditions(&buf, 1, heightProfiles(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendition, _, err := image.Decode(bytes.NewReader(renditions[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := rendition.At(10, 10).RGBA(); r < 0xF000 || g < 0xF000 || b < 0xF000 {
+		t.Error("transparent parts should be white", r, g, b)
+	}
+}
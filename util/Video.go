@@ -0,0 +1,334 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Video formats, as detected by DetectFormat.
+const (
+	FormatMp4 string = "mp4"
+	FormatMov string = "mov"
+)
+
+// MediaVideo is the MediaType of the videos, photos having none.
+const MediaVideo string = "video"
+
+// DateFromVideo is the capture date source of the creation time of videos.
+const DateFromVideo = "video"
+
+// videoPosterCommand extracts the poster frame of videos.
+const videoPosterCommand = "ffmpeg"
+
+// maxMoovSize bounds the movie atom read in memory, to skip corrupted sizes.
+const maxMoovSize = 64 << 20
+
+// quickTimeEpoch is the origin of the QuickTime and MP4 dates.
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	iso6709Regexp          = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+	appleCreationDateKey   = "com.apple.quicktime.creationdate"
+	appleLocationKey       = "com.apple.quicktime.location.ISO6709"
+	appleContentIDKey      = "com.apple.quicktime.content.identifier"
+	appleCreationDateTimes = []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z07:00"}
+)
+
+// VideoMetadata is what ParseVideo finds in the atoms of a video.
+type VideoMetadata struct {
+	// CreationTime is zero when not set. CreationOffset tells whether it is
+	// in the time zone it was taken in, or in UTC.
+	CreationTime   time.Time
+	CreationOffset bool
+	Duration       time.Duration
+	// Width and Height are the display size, rotation applied.
+	Width       int
+	Height      int
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+	// ContentIdentifier is the identifier the video of a Live Photo shares
+	// with its image.
+	ContentIdentifier string
+}
+
+// IsVideo tells whether format, as detected by DetectFormat, is a video.
+func IsVideo(format string) bool {
+	return format == FormatMp4 || format == FormatMov
+}
+
+// ParseVideo reads the metadata of an MP4 or QuickTime video from the atoms
+// of its movie atom: mvhd for the creation time and duration, tkhd of the
+// video track for the size and rotation, udta ©xyz or the Apple meta keys
+// for the location (and the creation date with its time zone, and the
+// content identifier of Live Photos).
+func ParseVideo(rs io.ReadSeeker) (VideoMetadata, error) {
+	meta := VideoMetadata{}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return meta, err
+	}
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= end; {
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return meta, err
+		}
+		if _, err := io.ReadFull(rs, header[:8]); err != nil {
+			return meta, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(rs, header[8:16]); err != nil {
+				return meta, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerSize {
+			return meta, errors.New("invalid atom size")
+		}
+		if string(header[4:8]) == "moov" {
+			if size-headerSize > maxMoovSize {
+				return meta, errors.New("movie atom too large")
+			}
+			moov := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(rs, moov); err != nil {
+				return meta, err
+			}
+			meta.parseMoov(moov)
+			return meta, nil
+		}
+		offset += size
+	}
+	return meta, errors.New("no movie atom found")
+}
+
+// atoms calls fn with the type and content of each atom of data.
+func atoms(data []byte, fn func(typ string, content []byte)) {
+	for len(data) >= 8 {
+		size, headerSize := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[headerSize:size])
+		data = data[size:]
+	}
+}
+
+func (meta *VideoMetadata) parseMoov(moov []byte) {
+	atoms(moov, func(typ string, content []byte) {
+		switch typ {
+		case "mvhd":
+			meta.parseMvhd(content)
+		case "trak":
+			meta.parseTrak(content)
+		case "udta":
+			atoms(content, func(typ string, content []byte) {
+				switch typ {
+				case "\xa9xyz":
+					// 16 bits length, 16 bits language, then the location
+					if len(content) > 4 {
+						meta.setLocation(string(content[4:]))
+					}
+				case "meta":
+					meta.parseMeta(content)
+				}
+			})
+		case "meta":
+			meta.parseMeta(content)
+		}
+	})
+}
+
+func (meta *VideoMetadata) parseMvhd(mvhd []byte) {
+	var creation, timescale, duration uint64
+	switch {
+	case len(mvhd) >= 32 && mvhd[0] == 1:
+		creation = binary.BigEndian.Uint64(mvhd[4:])
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	case len(mvhd) >= 20:
+		creation = uint64(binary.BigEndian.Uint32(mvhd[4:]))
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	default:
+		return
+	}
+	if creation != 0 && meta.CreationTime.IsZero() {
+		meta.CreationTime = quickTimeEpoch.Add(time.Duration(creation) * time.Second)
+	}
+	if timescale != 0 {
+		meta.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+	}
+}
+
+// parseTrak reads the display size of the video track, from its track
+// header once the handler of its media says it is a video.
+func (meta *VideoMetadata) parseTrak(trak []byte) {
+	var tkhd []byte
+	video := false
+	atoms(trak, func(typ string, content []byte) {
+		switch typ {
+		case "tkhd":
+			tkhd = content
+		case "mdia":
+			atoms(content, func(typ string, content []byte) {
+				// version and flags, pre-defined, then the handler type
+				if typ == "hdlr" && len(content) >= 12 && string(content[8:12]) == "vide" {
+					video = true
+				}
+			})
+		}
+	})
+	if !video || meta.Width != 0 || len(tkhd) < 84 {
+		return
+	}
+	// the matrix and the 16.16 fixed point size are at the end
+	matrix := tkhd[len(tkhd)-44:]
+	width := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+	height := int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+	if a := int32(binary.BigEndian.Uint32(matrix)); a == 0 {
+		// rotated by 90 or 270 degrees
+		width, height = height, width
+	}
+	meta.Width, meta.Height = width, height
+}
+
+// parseMeta reads the Apple metadata: the keys atom names the items of the
+// ilst atom, by index.
+func (meta *VideoMetadata) parseMeta(content []byte) {
+	// the ISO meta atom has a version and flags, the QuickTime one hasn't
+	if len(content) >= 12 && string(content[4:8]) != "hdlr" && string(content[4:8]) != "keys" {
+		content = content[4:]
+	}
+	keys := []string{}
+	values := map[int][]byte{}
+	atoms(content, func(typ string, content []byte) {
+		switch typ {
+		case "keys":
+			if len(content) < 8 {
+				return
+			}
+			// version and flags, entry count, then size, namespace and name
+			atoms(content[8:], func(namespace string, name []byte) {
+				keys = append(keys, string(name))
+			})
+		case "ilst":
+			atoms(content, func(typ string, item []byte) {
+				index := int(binary.BigEndian.Uint32([]byte(typ)))
+				atoms(item, func(typ string, data []byte) {
+					// type and locale, then the value
+					if typ == "data" && len(data) >= 8 {
+						values[index] = data[8:]
+					}
+				})
+			})
+		}
+	})
+	for i, key := range keys {
+		value, found := values[i+1]
+		if !found {
+			continue
+		}
+		switch key {
+		case appleLocationKey:
+			meta.setLocation(string(value))
+		case appleContentIDKey:
+			meta.ContentIdentifier = string(value)
+		case appleCreationDateKey:
+			for _, layout := range appleCreationDateTimes {
+				if t, err := time.Parse(layout, string(value)); err == nil {
+					meta.CreationTime, meta.CreationOffset = t, true
+					break
+				}
+			}
+		}
+	}
+}
+
+// setLocation parses an ISO 6709 location, as "+48.8577+002.2950+035.000/".
+func (meta *VideoMetadata) setLocation(location string) {
+	match := iso6709Regexp.FindStringSubmatch(location)
+	if match == nil {
+		return
+	}
+	lat, errLat := strconv.ParseFloat(match[1], 64)
+	long, errLong := strconv.ParseFloat(match[2], 64)
+	if errLat == nil && errLong == nil {
+		meta.Latitude, meta.Longitude, meta.HasLocation = lat, long, true
+	}
+}
+
+// SetVideoMetadata fills the media type, duration, size and location of the
+// photo of a video.
+func (p *Photo) SetVideoMetadata(meta VideoMetadata) {
+	p.MediaType = MediaVideo
+	p.Duration = meta.Duration.Seconds()
+	p.Width, p.Height = meta.Width, meta.Height
+	if meta.HasLocation {
+		p.Latitude, p.Longitude = meta.Latitude, meta.Longitude
+	}
+}
+
+// VideoDateTime returns when a video was taken, in the time zone it was
+// taken in when the video says it, or else in defaultLocation, and where
+// that time zone comes from.
+func (meta VideoMetadata) VideoDateTime(defaultLocation *time.Location) (time.Time, string, error) {
+	if meta.CreationTime.IsZero() {
+		return time.Time{}, "", errors.New("no creation time")
+	}
+	if meta.CreationOffset {
+		return meta.CreationTime, TimeZoneFromOffset, nil
+	}
+	return meta.CreationTime.In(defaultLocation), TimeZoneFromDefault, nil
+}
+
+// PosterAvailable tells whether poster frames can be extracted from videos,
+// which needs ffmpeg in the PATH.
+func PosterAvailable() bool {
+	return commandAvailable(videoPosterCommand)
+}
+
+// VideoPoster extracts the frame at the given time of the video file,
+// rotated the way the video is displayed.
+func VideoPoster(videoPath string, at time.Duration) (image.Image, error) {
+	dir, err := ioutil.TempDir("", "gogal")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "poster.png")
+	seconds := strconv.FormatFloat(at.Seconds(), 'f', 3, 64)
+	cmd := exec.Command(videoPosterCommand, "-v", "error", "-ss", seconds, "-i", videoPath, "-frames:v", "1", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.New(videoPosterCommand + " : " + err.Error() + " : " + string(output))
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// atom builds an atom of the given type, its content being the
+// concatenation of parts.
+func atom(typ string, parts ...[]byte) []byte {
+	content := bytes.Join(parts, nil)
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.BigEndian, uint32(8+len(content)))
+	buf.WriteString(typ)
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+func be32(values ...uint32) []byte {
+	buf := bytes.Buffer{}
+	for _, value := range values {
+		binary.Write(&buf, binary.BigEndian, value)
+	}
+	return buf.Bytes()
+}
+
+// fakeVideo builds a 1920x1080 video shot in portrait, lasting 12.5s.
+func fakeVideo(creation time.Time, appleDate string) []byte {
+	mvhd := atom("mvhd", be32(0, uint32(creation.Sub(quickTimeEpoch)/time.Second), 0, 1000, 12500), make([]byte, 80))
+	// matrix rotated by 90 degrees: 0 1 0 / -1 0 0 / 0 0 1
+	matrix := be32(0, 0x10000, 0, 0xFFFF0000, 0, 0, 0, 0, 0x40000000)
+	tkhd := atom("tkhd", make([]byte, 40), matrix, be32(1920<<16, 1080<<16))
+	soundTrak := atom("trak", atom("tkhd", make([]byte, 76), be32(0, 0)), atom("mdia", atom("hdlr", be32(0, 0), []byte("soun"))))
+	videoTrak := atom("trak", tkhd, atom("mdia", atom("hdlr", be32(0, 0), []byte("vide"))))
+	udta := atom("udta", atom("\xa9xyz", []byte{0, 26, 0x15, 0xc7}, []byte("+48.8577+002.2950+035.000/")))
+	keys := atom("keys", be32(0, 1), atom("mdta", []byte(appleCreationDateKey)))
+	ilst := atom("ilst", atom("\x00\x00\x00\x01", atom("data", be32(1, 0), []byte(appleDate))))
+	meta := atom("meta", atom("hdlr", be32(0, 0), []byte("mdta")), keys, ilst)
+	return bytes.Join([][]byte{
+		atom("ftyp", []byte("qt  "), be32(0)),
+		atom("mdat", make([]byte, 100)),
+		atom("moov", mvhd, soundTrak, videoTrak, udta, meta),
+	}, nil)
+}
+
+func TestParseVideo(t *testing.T) {
+	creation := time.Date(2023, 5, 14, 10, 30, 0, 0, time.UTC)
+	video := fakeVideo(creation, "2023-05-14T12:30:00+0200")
+	if DetectFormat(video) != FormatMov {
+		t.Error("QuickTime video expected")
+	}
+	meta, err := ParseVideo(bytes.NewReader(video))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Duration != 12500*time.Millisecond {
+		t.Error("wrong duration", meta.Duration)
+	}
+	if meta.Width != 1080 || meta.Height != 1920 {
+		t.Error("wrong size", meta.Width, meta.Height)
+	}
+	if !meta.HasLocation || meta.Latitude != 48.8577 || meta.Longitude != 2.295 {
+		t.Error("wrong location", meta.Latitude, meta.Longitude)
+	}
+	taken, timeZoneSource, err := meta.VideoDateTime(time.UTC)
+	if err != nil || !taken.Equal(creation) || timeZoneSource != TimeZoneFromOffset {
+		t.Error("wrong creation date", taken, timeZoneSource, err)
+	}
+	if _, offset := taken.Zone(); offset != 2*3600 {
+		t.Error("the creation date should be in the time zone it was taken in", offset)
+	}
+
+	// without the Apple creation date, the UTC creation time is used
+	meta, err = ParseVideo(bytes.NewReader(fakeVideo(creation, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, _ := time.LoadLocation("Europe/Paris")
+	taken, timeZoneSource, _ = meta.VideoDateTime(paris)
+	if !taken.Equal(creation) || timeZoneSource != TimeZoneFromDefault || taken.Location() != paris {
+		t.Error("wrong creation date", taken, timeZoneSource)
+	}
+
+	photo := Photo{}
+	photo.SetVideoMetadata(meta)
+	if photo.MediaType != MediaVideo || photo.Duration != 12.5 || photo.Width != 1080 || !photo.HasLocation() {
+		t.Error("wrong video metadata", photo)
+	}
+
+	if _, err := ParseVideo(bytes.NewReader(atom("ftyp", []byte("isom")))); err == nil {
+		t.Error("error expected without movie atom")
+	}
+}